@@ -0,0 +1,237 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Authenticator 为下载器发出的每个请求设置认证信息
+type Authenticator interface {
+	// Authenticate 在请求发送之前设置认证信息，返回错误时不发送请求
+	Authenticate(req *http.Request) error
+}
+
+// Refresher 可以刷新凭据的认证方式
+//
+// 服务器以 401 Unauthorized 拒绝请求时，下载器调用 Refresh 后用新的凭据重试一次该请求，
+// 适用于下载时间长于令牌有效期的情况
+type Refresher interface {
+	Authenticator
+	// Refresh 刷新凭据，req 是被拒绝的请求；多个分片同时被拒绝时会并发调用
+	Refresh(req *http.Request) error
+}
+
+// AuthenticatorFunc 将普通函数转换为 Authenticator
+type AuthenticatorFunc func(req *http.Request) error
+
+// Authenticate 实现 Authenticator 接口
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// WithAuth 设置认证方式，作用于HEAD请求、探测请求、分片请求和单线程下载请求
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithAuth(NewBearerAuth(token)))
+func WithAuth(a Authenticator) OptionFunc {
+	return func(o *Options) {
+		o.Auth = a
+	}
+}
+
+// NewBasicAuth 创建HTTP Basic认证
+func NewBasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// NewBearerAuth 创建使用固定令牌的Bearer认证
+func NewBearerAuth(token string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// TokenFunc 获取新的访问令牌
+type TokenFunc func(ctx context.Context) (string, error)
+
+// tokenAuth 缓存访问令牌、在服务器拒绝时刷新的Bearer认证
+type tokenAuth struct {
+	fetch TokenFunc
+	mu    sync.Mutex
+	token string
+}
+
+// NewTokenAuth 创建可刷新令牌的Bearer认证
+//
+// 第一次请求时调用 fetch 获取令牌并缓存；服务器返回401时再次调用 fetch 获取新的令牌，并重试被拒绝的请求一次。
+// 多个分片同时被拒绝时只刷新一次
+//
+// 示例:
+//
+//	auth := NewTokenAuth(func(ctx context.Context) (string, error) {
+//	    return oauth.Token(ctx)
+//	})
+//	dl, err := NewDownloader(url, WithAuth(auth))
+func NewTokenAuth(fetch TokenFunc) Refresher {
+	return &tokenAuth{fetch: fetch}
+}
+
+// Authenticate 实现 Authenticator 接口
+func (a *tokenAuth) Authenticate(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" {
+		token, err := a.fetch(req.Context())
+		if err != nil {
+			return fmt.Errorf("failed to get token: %w", err)
+		}
+		a.token = token
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// Refresh 实现 Refresher 接口，被拒绝的请求使用的不是当前令牌时说明已经刷新过
+func (a *tokenAuth) Refresh(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req.Header.Get("Authorization") != "Bearer "+a.token {
+		return nil
+	}
+	token, err := a.fetch(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to refresh token: %w", err)
+	}
+	a.token = token
+	return nil
+}
+
+// netrcEntry .netrc 中一台主机的登录信息
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// netrcAuth 按主机名从 .netrc 中查找登录信息的Basic认证
+type netrcAuth struct {
+	machines map[string]*netrcEntry // 键为主机名，default 条目的键为空字符串
+}
+
+// NewNetrcAuth 读取 .netrc 文件，按请求的主机名设置Basic认证
+//
+// path 为空时依次使用环境变量 NETRC 和用户主目录下的 .netrc（Windows上为 _netrc）。
+// 没有匹配的主机时使用 default 条目；请求已经带有 Authorization 请求头时不做修改
+//
+// 参数:
+//
+//	path - .netrc 文件路径
+//
+// 返回:
+//
+//	Authenticator - 认证方式
+//	error - 文件无法读取时返回错误
+func NewNetrcAuth(path string) (Authenticator, error) {
+	if path == "" {
+		path = netrcPath()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netrc: %w", err)
+	}
+	return &netrcAuth{machines: parseNetrc(string(data))}, nil
+}
+
+// Authenticate 实现 Authenticator 接口
+func (a *netrcAuth) Authenticate(req *http.Request) error {
+	if req.Header.Get("Authorization") != "" {
+		return nil
+	}
+	e := a.machines[req.URL.Hostname()]
+	if e == nil {
+		e = a.machines[""]
+	}
+	if e != nil && (e.login != "" || e.password != "") {
+		req.SetBasicAuth(e.login, e.password)
+	}
+	return nil
+}
+
+// netrcPath 返回默认的 .netrc 文件路径
+func netrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+	home, _ := os.UserHomeDir()
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc 解析 .netrc 文件内容，同一主机出现多次时使用第一条
+//
+// 支持 machine、default、login、password、account 和 macdef，# 开头的行为注释
+func parseNetrc(data string) map[string]*netrcEntry {
+	machines := make(map[string]*netrcEntry)
+	var cur *netrcEntry
+	inMacro := false
+
+	for _, line := range strings.Split(data, "\n") {
+		// 宏定义持续到下一个空行
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+	tokens:
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine", "default":
+				name := ""
+				if fields[i] == "machine" {
+					name = value
+					i++
+				}
+				cur = &netrcEntry{}
+				if _, ok := machines[name]; !ok {
+					machines[name] = cur
+				}
+			case "login":
+				if cur != nil {
+					cur.login = value
+				}
+				i++
+			case "password":
+				if cur != nil {
+					cur.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				break tokens
+			}
+		}
+	}
+	return machines
+}
@@ -0,0 +1,224 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// authTestServer 只接受指定 Authorization 请求头的测试服务器，记录被拒绝的请求数
+type authTestServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	accept   string
+	rejected atomic.Int32
+}
+
+// newAuthTestServer 创建测试服务器，accept 为接受的 Authorization 请求头
+func newAuthTestServer(data []byte, accept string) *authTestServer {
+	s := &authTestServer{accept: accept}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		accept := s.accept
+		s.mu.Unlock()
+		if r.Header.Get("Authorization") != accept {
+			s.rejected.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	return s
+}
+
+// setAccept 更换服务器接受的 Authorization 请求头
+func (s *authTestServer) setAccept(v string) {
+	s.mu.Lock()
+	s.accept = v
+	s.mu.Unlock()
+}
+
+// TestAuthenticators 测试内置的认证方式作用于所有请求
+func TestAuthenticators(t *testing.T) {
+	data := testData(32 * 1024)
+	basic := "Basic dXNlcjpwYXNz" // user:pass
+
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	os.WriteFile(netrc, []byte("machine 127.0.0.1\n  login user\n  password pass\n"), FilePerm)
+	netrcAuth, err := NewNetrcAuth(netrc)
+	if err != nil {
+		t.Fatalf("NewNetrcAuth() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		auth   Authenticator
+		accept string
+	}{
+		{"basic", NewBasicAuth("user", "pass"), basic},
+		{"bearer", NewBearerAuth("abc"), "Bearer abc"},
+		{"netrc", netrcAuth, basic},
+		{"func", AuthenticatorFunc(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Custom "+req.URL.Hostname())
+			return nil
+		}), "Custom 127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAuthTestServer(data, tt.accept)
+			defer server.Close()
+
+			target := filepath.Join(dir, tt.name+".bin")
+			d := newTestDownloader(t, server.URL,
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(3),
+				WithAuth(tt.auth),
+			)
+			if err := d.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			if n := server.rejected.Load(); n != 0 {
+				t.Errorf("server rejected %d requests", n)
+			}
+		})
+	}
+}
+
+// TestTokenRefresh 测试令牌在下载过程中过期时刷新令牌并重试
+func TestTokenRefresh(t *testing.T) {
+	data := testData(64 * 1024)
+	server := newAuthTestServer(data, "Bearer t1")
+	defer server.Close()
+
+	var fetches atomic.Int32
+	auth := NewTokenAuth(func(ctx context.Context) (string, error) {
+		return fmt.Sprintf("t%d", fetches.Add(1)), nil
+	})
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "refresh.bin")
+	d := newTestDownloader(t, server.URL,
+		WithFileName(target),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(4),
+		WithAuth(auth),
+		// 探测之后令牌过期，所有分片的第一次请求都会被拒绝
+		WithRequestMutator(func(req *http.Request) error {
+			if req.Method == http.MethodGet {
+				server.setAccept("Bearer t2")
+			}
+			return nil
+		}),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file mismatch, err = %v", err)
+	}
+	// 多个分片同时被拒绝时只刷新一次
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("token fetched %d times, want 2", n)
+	}
+	if server.rejected.Load() == 0 {
+		t.Error("no request was rejected, token expiry was not exercised")
+	}
+}
+
+// TestTokenRefreshFailure 测试刷新后仍被拒绝时只重试一次，刷新失败时返回错误
+func TestTokenRefreshFailure(t *testing.T) {
+	server := newAuthTestServer(testData(1024), "Bearer never")
+	defer server.Close()
+
+	errRevoked := errors.New("refresh token revoked")
+	tests := []struct {
+		name    string
+		fetch   TokenFunc
+		wantErr error
+	}{
+		{"still rejected", func(context.Context) (string, error) { return "wrong", nil }, nil},
+		{"refresh error", func() TokenFunc {
+			var calls atomic.Int32
+			return func(context.Context) (string, error) {
+				if calls.Add(1) > 1 {
+					return "", errRevoked
+				}
+				return "wrong", nil
+			}
+		}(), errRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.rejected.Store(0)
+			dir := t.TempDir()
+			d := newTestDownloader(t, server.URL,
+				WithFileName(filepath.Join(dir, "denied.bin")),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithAuth(NewTokenAuth(tt.fetch)),
+			)
+			err := d.Start()
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			// 每个被拒绝的请求只重试一次
+			if n := server.rejected.Load(); tt.wantErr == nil && n%2 != 0 {
+				t.Errorf("server rejected %d requests, want each request retried exactly once", n)
+			}
+		})
+	}
+}
+
+// TestParseNetrc 测试解析 .netrc 文件
+func TestParseNetrc(t *testing.T) {
+	data := `# comment line
+machine example.com login alice password secret1
+machine example.com login ignored password ignored
+macdef init
+  cd /pub
+  machine fake.example.com login macro password macro
+
+machine files.example.org
+	login bob
+	account acct
+	password secret2
+default login anonymous password guest@
+`
+	machines := parseNetrc(data)
+	tests := []struct {
+		host, login, password string
+		found                 bool
+	}{
+		{"example.com", "alice", "secret1", true},
+		{"files.example.org", "bob", "secret2", true},
+		{"", "anonymous", "guest@", true},
+		{"fake.example.com", "", "", false},
+	}
+	for _, tt := range tests {
+		e, ok := machines[tt.host]
+		if ok != tt.found {
+			t.Errorf("host %q found = %v, want %v", tt.host, ok, tt.found)
+			continue
+		}
+		if ok && (e.login != tt.login || e.password != tt.password) {
+			t.Errorf("host %q = %s/%s, want %s/%s", tt.host, e.login, e.password, tt.login, tt.password)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package dl
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// WithConditionalDownload 设置是否只在远程文件变化时重新下载
+//
+// 启用后，下载完成时在附属文件中记录ETag和Last-Modified，并把本地文件的修改时间设置为远程文件的修改时间。
+// 之后再次 Start 时以 If-None-Match / If-Modified-Since 发送条件请求，
+// 服务器返回 304 Not Modified 时不再下载，结果为 OutcomeNotModified，并回调 OnNotModified。
+//
+// 参数:
+//
+//	enable - 是否启用条件下载
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithFileName("mirror/file.iso"), WithConditionalDownload(true))
+func WithConditionalDownload(enable bool) OptionFunc {
+	return func(o *Options) {
+		o.Conditional = enable
+	}
+}
+
+// WithRemoteTime 设置是否将下载完成的文件的修改时间设置为服务器返回的 Last-Modified
+//
+// 与 curl -R 类似；分段下载和续传完成后同样生效。服务器未返回 Last-Modified 时保留当前时间
+//
+// 参数:
+//
+//	enable - 是否使用远程文件的修改时间
+func WithRemoteTime(enable bool) OptionFunc {
+	return func(o *Options) {
+		o.RemoteTime = enable
+	}
+}
+
+// OnNotModified 设置远程文件未变化、跳过下载时的回调函数
+//
+// 参数:
+//
+//	f - 回调函数，接收未变化的文件名
+func (d *Downloader) OnNotModified(f func(filename string)) {
+	d.onNotModified = f
+}
+
+// loadCondition 读取上次下载记录的ETag和Last-Modified，用于本次的条件请求
+//
+// 只有目标文件仍然存在、且与记录的大小一致时才发送条件请求
+func (d *Downloader) loadCondition() {
+	d.condition = nil
+	if !d.options.Conditional || d.outcome != OutcomeOverwritten {
+		return
+	}
+	m := d.loadMeta()
+	if m == nil || m.URL != d.url || (m.ETag == "" && m.LastModified == "") {
+		return
+	}
+	if fi, err := os.Stat(d.options.FilePath); err != nil || !fi.Mode().IsRegular() || fi.Size() != m.Size {
+		return
+	}
+	d.condition = m
+}
+
+// setConditionHeaders 为探测请求加上条件请求头
+func (d *Downloader) setConditionHeaders(req *http.Request) {
+	m := d.condition
+	if m == nil {
+		return
+	}
+	if m.ETag != "" {
+		req.Header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		req.Header.Set("If-Modified-Since", m.LastModified)
+	}
+}
+
+// notModified 服务器返回304时结束本次下载，保留远程文件的修改时间
+func (d *Downloader) notModified(info *remoteInfo) error {
+	lastModified := info.lastModified
+	if lastModified == "" && d.condition != nil {
+		lastModified = d.condition.LastModified
+	}
+	if err := setModTime(d.options.FilePath, lastModified); err != nil {
+		return err
+	}
+
+	d.setResult(OutcomeNotModified)
+	if d.onNotModified != nil {
+		d.onNotModified(d.options.FileName)
+	}
+	return nil
+}
+
+// setModTime 将文件的修改时间设置为 Last-Modified 表示的时间，值为空或无法解析时不做处理
+func setModTime(path, lastModified string) error {
+	t, err := http.ParseTime(lastModified)
+	if err != nil {
+		return nil
+	}
+	// 访问时间保持不变
+	if err = os.Chtimes(path, time.Time{}, t); err != nil {
+		return fmt.Errorf("failed to set modification time: %w", err)
+	}
+	return nil
+}
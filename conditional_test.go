@@ -0,0 +1,282 @@
+package dl
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// conditionalTestServer 支持条件请求的测试服务器，内容可以在测试中替换
+type conditionalTestServer struct {
+	*httptest.Server
+	mu          sync.Mutex
+	data        []byte
+	etag        string
+	modTime     time.Time
+	notModified atomic.Int32 // 返回304的次数
+	gets        atomic.Int32 // 返回数据的GET请求数
+}
+
+// newConditionalTestServer 创建测试服务器，由 http.ServeContent 处理条件请求和Range请求
+func newConditionalTestServer(data []byte, etag string, modTime time.Time) *conditionalTestServer {
+	s := &conditionalTestServer{data: data, etag: etag, modTime: modTime}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		data, etag, modTime := s.data, s.etag, s.modTime
+		s.mu.Unlock()
+
+		w.Header().Set("ETag", etag)
+		rec := &statusRecorder{ResponseWriter: w}
+		http.ServeContent(rec, r, "", modTime, bytes.NewReader(data))
+		if rec.status == http.StatusNotModified {
+			s.notModified.Add(1)
+		} else if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+			s.gets.Add(1)
+		}
+	}))
+	return s
+}
+
+// update 替换服务器上的文件内容
+func (s *conditionalTestServer) update(data []byte, etag string, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.etag, s.modTime = data, etag, modTime
+}
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// TestConditionalDownload 测试远程文件未变化时返回304并跳过下载
+func TestConditionalDownload(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	data := testData(32 * 1024)
+	server := newConditionalTestServer(data, `"v1"`, modTime)
+	defer server.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "mirror.bin")
+	start := func(wantOutcome Outcome, wantNotModified bool) {
+		t.Helper()
+		d := newTestDownloader(t, server.URL,
+			WithFileName(target),
+			WithBaseDir(filepath.Join(dir, "cache")),
+			WithConcurrency(2),
+			WithConditionalDownload(true),
+		)
+		var notModified, finished bool
+		d.OnNotModified(func(string) { notModified = true })
+		d.OnDownloadFinished(func(string) { finished = true })
+		if err := d.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		if got := d.Result().Outcome; got != wantOutcome {
+			t.Errorf("outcome = %v, want %v", got, wantOutcome)
+		}
+		if notModified != wantNotModified || finished == wantNotModified {
+			t.Errorf("OnNotModified called = %v, OnDownloadFinished called = %v", notModified, finished)
+		}
+	}
+	checkFile := func(want []byte, wantMod time.Time) {
+		t.Helper()
+		got, err := os.ReadFile(target)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("downloaded file mismatch, err = %v", err)
+		}
+		if fi, _ := os.Stat(target); !fi.ModTime().Equal(wantMod) {
+			t.Errorf("mtime = %v, want %v", fi.ModTime(), wantMod)
+		}
+	}
+
+	start(OutcomeDownloaded, false)
+	checkFile(data, modTime)
+
+	// 文件未变化：不再下载，并恢复远程文件的修改时间
+	os.Chtimes(target, time.Now(), time.Now())
+	gets := server.gets.Load()
+	start(OutcomeNotModified, true)
+	checkFile(data, modTime)
+	if server.gets.Load() != gets || server.notModified.Load() == 0 {
+		t.Errorf("GET requests %d -> %d, 304 responses %d", gets, server.gets.Load(), server.notModified.Load())
+	}
+
+	// 文件已变化：重新下载
+	changed := testData(40 * 1024)
+	changed[0] ^= 0xff
+	newMod := modTime.Add(time.Hour)
+	server.update(changed, `"v2"`, newMod)
+	start(OutcomeOverwritten, false)
+	checkFile(changed, newMod)
+
+	// 本地文件被修改后不再发送条件请求
+	os.WriteFile(target, []byte("local edit"), FilePerm)
+	start(OutcomeOverwritten, false)
+	checkFile(changed, newMod)
+}
+
+// TestConditionalRestartProgress 测试同一个下载器在远程文件变化后再次下载时，进度从零开始统计
+func TestConditionalRestartProgress(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	data := testData(32 * 1024)
+	server := newConditionalTestServer(data, `"v1"`, modTime)
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := newTestDownloader(t, server.URL,
+		WithFileName(filepath.Join(dir, "restart.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(2),
+		WithConditionalDownload(true),
+	)
+	var mu sync.Mutex
+	var last, overflow int64
+	d.OnProgress(func(loaded, total int64, rate string) {
+		mu.Lock()
+		defer mu.Unlock()
+		last = loaded
+		if loaded > total {
+			overflow = loaded
+		}
+	})
+
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	changed := testData(40 * 1024)
+	changed[0] ^= 0xff
+	server.update(changed, `"v2"`, modTime.Add(time.Hour))
+	if err := d.Start(); err != nil {
+		t.Fatalf("second Start() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if overflow != 0 {
+		t.Errorf("progress reported %d bytes, more than the total", overflow)
+	}
+	if last != int64(len(changed)) {
+		t.Errorf("final progress = %d, want %d", last, len(changed))
+	}
+}
+
+// TestUnexpectedNotModified 测试下载器没有发送条件请求时，服务器返回304不会被当作文件未变化
+func TestUnexpectedNotModified(t *testing.T) {
+	server := newConditionalTestServer(testData(16*1024), `"x"`, time.Now())
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		autoName    bool // 文件名取决于探测结果
+		conditional bool
+	}{
+		{"custom header", false, false},
+		{"custom header with auto name", true, false},
+		{"conditional without record", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := []OptionFunc{
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithHeader("If-None-Match", `"x"`),
+			}
+			if !tt.autoName {
+				opts = append(opts, WithFileName(filepath.Join(dir, "file.bin")))
+			}
+			if tt.conditional {
+				opts = append(opts, WithConditionalDownload(true))
+			}
+			d := newTestDownloader(t, server.URL+"/file.bin", opts...)
+			var notModified bool
+			d.OnNotModified(func(string) { notModified = true })
+
+			err := d.Start()
+			if err == nil || notModified {
+				t.Fatalf("Start() error = %v, OnNotModified called = %v, want an error", err, notModified)
+			}
+			if server.notModified.Load() == 0 {
+				t.Fatal("server did not answer with 304")
+			}
+		})
+	}
+}
+
+// TestRemoteTime 测试下载完成后使用 Last-Modified 设置文件的修改时间
+func TestRemoteTime(t *testing.T) {
+	modTime := time.Date(2023, 7, 15, 8, 30, 0, 0, time.UTC)
+	data := testData(256 * 1024)
+	server := newConditionalTestServer(data, `"v1"`, modTime)
+	defer server.Close()
+
+	// 忽略Range请求的服务器，只能单线程下载
+	single := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Write(data)
+	}))
+	defer single.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		enable bool
+		stop   bool // 下载开始后先停止一次再继续
+	}{
+		{"segmented", server.URL, true, false},
+		{"resumed segments", server.URL, true, true},
+		{"single stream", single.URL, true, false},
+		{"disabled", server.URL, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "timed.bin")
+			d := newTestDownloader(t, tt.url,
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+				WithRemoteTime(tt.enable),
+			)
+			var once sync.Once
+			if tt.stop {
+				d.OnProgress(func(loaded, total int64, rate string) {
+					once.Do(func() { d.Stop() })
+				})
+			}
+
+			if err := d.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if tt.stop {
+				d.OnProgress(nil)
+				if err := d.Start(); err != nil {
+					t.Fatalf("resumed Start() error = %v", err)
+				}
+			}
+
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			fi, _ := os.Stat(target)
+			if fi.ModTime().Equal(modTime) != tt.enable {
+				t.Errorf("mtime = %v, remote time %v, enabled %v", fi.ModTime(), modTime, tt.enable)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package dl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cookieFilePerm Cookie文件的权限，Cookie通常包含登录凭据
+const cookieFilePerm = 0600
+
+// WithCookieJar 设置下载器使用的Cookie容器
+//
+// 未设置时每个下载器使用独立的内存Cookie容器，HEAD和探测响应设置的Cookie同样会带到之后的分片请求中。
+// 需要登录的网站可以先用同一个容器完成登录请求，再把容器交给下载器
+//
+// 示例:
+//
+//	jar := NewCookieJar()
+//	client := &http.Client{Jar: jar}
+//	client.PostForm("https://portal.example.com/login", url.Values{"user": {"u"}, "pass": {"p"}})
+//	dl, err := NewDownloader(url, WithCookieJar(jar))
+func WithCookieJar(jar http.CookieJar) OptionFunc {
+	return func(o *Options) {
+		o.CookieJar = jar
+	}
+}
+
+// cookieEntry 记录的一个Cookie，用于保存为 cookies.txt
+type cookieEntry struct {
+	domain   string    // 域名，不含前导的点
+	hostOnly bool      // 只发送给 domain 本身，不包括子域名
+	path     string    // 路径
+	secure   bool      // 只通过HTTPS发送
+	httpOnly bool      // 仅限HTTP
+	expires  time.Time // 过期时间，零值表示会话Cookie
+	name     string
+	value    string
+}
+
+// CookieJar 可以读写 Netscape cookies.txt 格式文件的Cookie容器，实现 http.CookieJar 接口
+//
+// Cookie的匹配规则由标准库的 cookiejar 实现，CookieJar 另外记录所有Cookie以便保存到文件
+type CookieJar struct {
+	jar     *cookiejar.Jar
+	mu      sync.Mutex
+	entries map[string]*cookieEntry // 键为 域名;路径;名称
+}
+
+// NewCookieJar 创建空的Cookie容器
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &CookieJar{jar: jar, entries: make(map[string]*cookieEntry)}
+}
+
+// LoadCookies 从 Netscape cookies.txt 格式的文件（curl、wget和浏览器扩展导出的格式）创建Cookie容器
+//
+// 参数:
+//
+//	path - cookies.txt 文件路径
+//
+// 返回:
+//
+//	*CookieJar - 包含文件中未过期Cookie的容器
+//	error - 文件无法读取时返回错误
+func LoadCookies(path string) (*CookieJar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookie file: %w", err)
+	}
+	defer f.Close()
+
+	j := NewCookieJar()
+	if err = j.load(f); err != nil {
+		return nil, fmt.Errorf("failed to read cookie file: %w", err)
+	}
+	return j, nil
+}
+
+// Save 将容器中未过期的Cookie（包括会话Cookie）保存为 Netscape cookies.txt 格式的文件
+func (j *CookieJar) Save(path string) error {
+	j.mu.Lock()
+	keys := make([]string, 0, len(j.entries))
+	for k := range j.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	now := time.Now()
+	for _, k := range keys {
+		e := j.entries[k]
+		if !e.expires.IsZero() && !e.expires.After(now) {
+			continue
+		}
+		domain, sub := e.domain, "FALSE"
+		if !e.hostOnly {
+			domain, sub = "."+e.domain, "TRUE"
+		}
+		if e.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !e.expires.IsZero() {
+			expires = e.expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, sub, e.path, strings.ToUpper(strconv.FormatBool(e.secure)), expires, e.name, e.value)
+	}
+	j.mu.Unlock()
+
+	if err := os.WriteFile(path, []byte(b.String()), cookieFilePerm); err != nil {
+		return fmt.Errorf("failed to write cookie file: %w", err)
+	}
+	return nil
+}
+
+// SetCookies 实现 http.CookieJar 接口
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		e := &cookieEntry{
+			domain:   host,
+			hostOnly: true,
+			path:     c.Path,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+			name:     c.Name,
+			value:    c.Value,
+		}
+		if c.Domain != "" {
+			e.domain, e.hostOnly = strings.TrimPrefix(strings.ToLower(c.Domain), "."), false
+			// 与请求的主机不匹配的Cookie会被拒绝
+			if host != e.domain && !strings.HasSuffix(host, "."+e.domain) {
+				continue
+			}
+		}
+		if !strings.HasPrefix(e.path, "/") {
+			e.path = defaultCookiePath(u.Path)
+		}
+
+		key := e.domain + ";" + e.path + ";" + e.name
+		switch {
+		case c.MaxAge < 0:
+			delete(j.entries, key)
+			continue
+		case c.MaxAge > 0:
+			e.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			if !c.Expires.After(now) {
+				delete(j.entries, key)
+				continue
+			}
+			e.expires = c.Expires
+		}
+		j.entries[key] = e
+	}
+}
+
+// Cookies 实现 http.CookieJar 接口
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// load 读取 cookies.txt 格式的内容，跳过无法解析的行和已过期的Cookie
+func (j *CookieJar) load(r io.Reader) error {
+	now := time.Now()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		} else if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			continue
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		secure := strings.EqualFold(fields[3], "TRUE")
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			if c.Expires = time.Unix(expires, 0); !c.Expires.After(now) {
+				continue
+			}
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			c.Domain = domain
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: c.Path}, []*http.Cookie{c})
+	}
+	return scanner.Err()
+}
+
+// defaultCookiePath 返回未指定Path的Cookie的默认路径（RFC 6265 5.1.4）
+func defaultCookiePath(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
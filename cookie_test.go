@@ -0,0 +1,168 @@
+package dl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createCookieTestServer 创建需要会话Cookie的测试服务器
+//
+// setOnHead 为true时HEAD响应设置Cookie，否则需要先POST /login 登录。返回缺少Cookie被拒绝的请求数
+func createCookieTestServer(data []byte, setOnHead bool) (*httptest.Server, *atomic.Int32) {
+	var rejected atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.FormValue("user") == "alice" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret", Path: "/"})
+		}
+	})
+	mux.HandleFunc("/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && setOnHead {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret", Path: "/"})
+		} else if c, err := r.Cookie("session"); err != nil || c.Value != "s3cret" {
+			rejected.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	return httptest.NewServer(mux), &rejected
+}
+
+// TestSessionCookies 测试探测响应设置的Cookie和登录得到的Cookie用于所有分片请求
+func TestSessionCookies(t *testing.T) {
+	data := testData(48 * 1024)
+
+	tests := []struct {
+		name  string
+		login bool
+	}{
+		{"set on HEAD", false},
+		{"login session", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, rejected := createCookieTestServer(data, !tt.login)
+			defer server.Close()
+
+			dir := t.TempDir()
+			target := filepath.Join(dir, "session.bin")
+			opts := []OptionFunc{
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+			}
+			if tt.login {
+				jar := NewCookieJar()
+				client := &http.Client{Jar: jar}
+				resp, err := client.PostForm(server.URL+"/login", url.Values{"user": {"alice"}})
+				if err != nil {
+					t.Fatalf("login error = %v", err)
+				}
+				resp.Body.Close()
+				opts = append(opts, WithCookieJar(jar))
+			}
+
+			d := newTestDownloader(t, server.URL+"/file.bin", opts...)
+			if err := d.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			if n := rejected.Load(); n != 0 {
+				t.Errorf("%d requests were sent without the session cookie", n)
+			}
+		})
+	}
+}
+
+// cookieNames 返回容器发送给 rawURL 的Cookie，格式为 name=value，按名称排序
+func cookieNames(j http.CookieJar, rawURL string) string {
+	u, _ := url.Parse(rawURL)
+	var names []string
+	for _, c := range j.Cookies(u) {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// TestCookieFile 测试读写 Netscape cookies.txt 格式的文件
+func TestCookieFile(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).Unix()
+	content := fmt.Sprintf(`# Netscape HTTP Cookie File
+# comment line
+
+.example.com	TRUE	/	FALSE	%[1]d	site	wide
+files.example.com	FALSE	/downloads	TRUE	%[1]d	token	abc
+#HttpOnly_.example.com	TRUE	/	FALSE	0	sid	session
+.example.com	TRUE	/	FALSE	1000	old	expired
+malformed line without tabs
+`, future)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.txt")
+	os.WriteFile(path, []byte(content), FilePerm)
+
+	jar, err := LoadCookies(path)
+	if err != nil {
+		t.Fatalf("LoadCookies() error = %v", err)
+	}
+
+	check := func(j http.CookieJar) {
+		t.Helper()
+		tests := []struct{ url, want string }{
+			{"http://example.com/", "sid=session,site=wide"},
+			{"http://www.example.com/page", "sid=session,site=wide"},
+			{"http://files.example.com/downloads/a.zip", "sid=session,site=wide"},
+			{"https://files.example.com/downloads/a.zip", "sid=session,site=wide,token=abc"},
+			{"https://files.example.com/other", "sid=session,site=wide"},
+			{"http://other.org/", ""},
+		}
+		for _, tt := range tests {
+			if got := cookieNames(j, tt.url); got != tt.want {
+				t.Errorf("Cookies(%s) = %q, want %q", tt.url, got, tt.want)
+			}
+		}
+	}
+	check(jar)
+
+	// 服务器删除了一个Cookie并设置了新的Cookie
+	u, _ := url.Parse("https://files.example.com/downloads/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "site", Domain: "example.com", Path: "/", MaxAge: -1},
+		{Name: "fresh", Value: "new", MaxAge: 3600},
+		{Name: "evil", Value: "x", Domain: "other.org"},
+	})
+
+	saved := filepath.Join(dir, "saved.txt")
+	if err = jar.Save(saved); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if info, _ := os.Stat(saved); info.Mode().Perm()&0077 != 0 && filepath.Separator == '/' {
+		t.Errorf("cookie file permission = %v, want owner only", info.Mode().Perm())
+	}
+	reloaded, err := LoadCookies(saved)
+	if err != nil {
+		t.Fatalf("LoadCookies(saved) error = %v", err)
+	}
+	if got, want := cookieNames(reloaded, "https://files.example.com/downloads/a.zip"), "fresh=new,sid=session,token=abc"; got != want {
+		t.Errorf("reloaded cookies = %q, want %q", got, want)
+	}
+	if got := cookieNames(reloaded, "http://other.org/"); got != "" {
+		t.Errorf("cookie for a foreign domain was saved: %q", got)
+	}
+}
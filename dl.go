@@ -2,14 +2,20 @@ package dl
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,6 +35,10 @@ const (
 	FilePerm = 0644
 	// DirPerm 目录权限
 	DirPerm = 0755
+	// UnknownSize 文件大小未知（例如分块传输编码）时回调中的总字节数
+	UnknownSize = -1
+	// DefaultMaxRedirects 默认最多跟随的重定向次数
+	DefaultMaxRedirects = 10
 )
 
 // 错误定义
@@ -39,6 +49,40 @@ var (
 	ErrInvalidURL = errors.New("invalid download URL")
 	// ErrInvalidConcurrency 并发数无效错误
 	ErrInvalidConcurrency = errors.New("concurrency must be greater than 0")
+	// ErrSourceMismatch 多个下载源的文件信息不一致错误
+	ErrSourceMismatch = errors.New("download sources do not match")
+	// ErrRangeIgnored 服务器忽略了Range请求错误
+	ErrRangeIgnored = errors.New("server ignored range request")
+	// ErrMaxSizeExceeded 文件大小超过上限错误
+	ErrMaxSizeExceeded = errors.New("download exceeds maximum size")
+	// ErrInsufficientSpace 磁盘可用空间不足错误
+	ErrInsufficientSpace = errors.New("insufficient disk space")
+	// ErrFileExists 目标文件已存在错误
+	ErrFileExists = errors.New("destination file already exists")
+	// ErrUnsafePath 服务器提供的文件名会写到目录之外错误
+	ErrUnsafePath = errors.New("path escapes the destination directory")
+	// ErrTooManyRedirects 重定向次数超过上限错误
+	ErrTooManyRedirects = errors.New("too many redirects")
+	// ErrRedirectDenied 按策略拒绝重定向到其他主机错误
+	ErrRedirectDenied = errors.New("cross-host redirect denied")
+	// ErrInvalidProxy 代理地址无效错误
+	ErrInvalidProxy = errors.New("invalid proxy URL")
+	// ErrCustomTransport 自定义客户端的传输层不支持代理、TLS等配置错误
+	ErrCustomTransport = errors.New("transport options require an *http.Transport")
+	// ErrInvalidPAC PAC脚本无效错误
+	ErrInvalidPAC = errors.New("invalid PAC script")
+	// ErrInvalidTLSOption CA证书、客户端证书或TLS版本配置无效错误
+	ErrInvalidTLSOption = errors.New("invalid TLS option")
+	// ErrPinMismatch 服务器公钥与固定的公钥都不匹配错误
+	ErrPinMismatch = errors.New("server public key does not match any pinned key")
+	// ErrInvalidResolve 地址映射或DNS服务器配置无效错误
+	ErrInvalidResolve = errors.New("invalid resolve option")
+	// ErrChecksumMismatch 文件校验和不一致错误
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrPieceMismatch 分块哈希校验失败错误
+	ErrPieceMismatch = errors.New("piece hash mismatch")
+	// ErrNoMetalinkFile Metalink文档中没有可下载的文件
+	ErrNoMetalinkFile = errors.New("metalink has no downloadable file")
 )
 
 // selfWriter 是一个线程安全的写入器，用于跟踪下载进度和速率
@@ -73,6 +117,49 @@ func (sw *selfWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
+// reset 清零已下载的字节数，用于放弃已下载的数据重新开始
+func (sw *selfWriter) reset() {
+	sw.mu.Lock()
+	sw.loaded = 0
+	sw.mu.Unlock()
+}
+
+// complete 下载完成时将总字节数设为实际下载的字节数，并通知一次进度
+// 用于文件大小未知的下载，使最后一次进度回调报告确定的总大小
+func (sw *selfWriter) complete() {
+	sw.mu.Lock()
+	sw.total = sw.loaded
+	loaded := sw.loaded
+	onProgress := sw.onProgress
+	sw.mu.Unlock()
+
+	if onProgress != nil {
+		rate := "0.00 MB/s"
+		if v := sw.rate.Load(); v != nil {
+			rate = v.(string)
+		}
+		onProgress(loaded, loaded, rate)
+	}
+}
+
+// restore 计入断点续传时已存在的n个字节，不影响速率计算
+func (sw *selfWriter) restore(n int64) {
+	sw.mu.Lock()
+	sw.loaded += n
+	loaded := sw.loaded
+	total := sw.total
+	onProgress := sw.onProgress
+	sw.mu.Unlock()
+
+	if onProgress != nil && n > 0 {
+		rate := "0.00 MB/s"
+		if v := sw.rate.Load(); v != nil {
+			rate = v.(string)
+		}
+		onProgress(loaded, total, rate)
+	}
+}
+
 // calcRate 持续计算并更新下载速率（每250ms更新一次）
 func (sw *selfWriter) calcRate(ctx context.Context) {
 	sw.rate.Store("0.00 MB/s")
@@ -126,8 +213,66 @@ type Options struct {
 	Concurrency int
 	// Resume 是否启用断点续传功能
 	Resume bool
-	// HTTPClient 自定义HTTP客户端，可用于配置代理、超时等
+	// HTTPClient 自定义HTTP客户端，下面的代理、TLS、拨号和超时配置在其传输层的基础上生效
 	HTTPClient *http.Client
+	// Proxy 为每个请求选择代理服务器，nil表示使用客户端原有的设置
+	Proxy func(*http.Request) (*url.URL, error)
+	// ProxyAuth 代理服务器的用户名和密码
+	ProxyAuth *url.Userinfo
+	// TLSConfig TLS配置
+	TLSConfig *tls.Config
+	// RootCAs 验证服务器证书使用的根证书，nil表示使用系统根证书
+	RootCAs *x509.CertPool
+	// ClientCerts 双向TLS认证使用的客户端证书
+	ClientCerts []tls.Certificate
+	// MinTLSVersion 最低TLS版本，例如 tls.VersionTLS12，0表示使用标准库的默认值
+	MinTLSVersion uint16
+	// PinnedPublicKeys 允许的服务器公钥（SubjectPublicKeyInfo）的SHA-256哈希，为空时不固定公钥
+	PinnedPublicKeys [][]byte
+	// Dialer 建立TCP连接使用的拨号器
+	Dialer *net.Dialer
+	// Resolve 主机名和端口到IP地址的固定映射，键为小写的 "host:port"
+	Resolve map[string][]string
+	// ConnectTo 连接地址的改写规则，键和值为 "host:port"，主机名或端口可以为空
+	ConnectTo map[string]string
+	// Resolver 解析主机名使用的DNS解析器，nil表示使用拨号器的默认解析
+	Resolver *net.Resolver
+	// ConnectTimeout 建立TCP连接的超时时间，0表示使用默认值
+	ConnectTimeout time.Duration
+	// ResponseHeaderTimeout 等待响应头的超时时间，0表示不限制
+	ResponseHeaderTimeout time.Duration
+	// HostGovernor 多个下载器共享的按主机连接数和速率限制
+	HostGovernor *HostGovernor
+	// Checksum 下载完成后校验的文件哈希
+	Checksum *Checksum
+	// Pieces 下载完成后逐块校验的哈希列表
+	Pieces *PieceHashes
+	// ExpectedSize 预期的文件大小，0表示不校验
+	ExpectedSize int64
+	// MaxSize 允许下载的最大字节数，0表示不限制
+	// 服务器返回的文件大小超过上限时拒绝下载，大小未知时在实际数据超过上限时中止
+	MaxSize int64
+	// ExistingFile 目标文件已存在时的处理策略
+	ExistingFile ExistingFilePolicy
+	// Conditional 是否只在远程文件变化时重新下载（If-None-Match / If-Modified-Since）
+	Conditional bool
+	// RemoteTime 是否将下载完成的文件的修改时间设置为服务器返回的 Last-Modified
+	RemoteTime bool
+	// Header 添加到所有请求的请求头
+	Header http.Header
+	// RequestMutators 每个请求发送之前依次调用的修改函数
+	RequestMutators []func(*http.Request) error
+	// Auth 为每个请求设置认证信息
+	Auth Authenticator
+	// CookieJar Cookie容器，未设置时每个下载器使用独立的内存容器
+	CookieJar http.CookieJar
+	// MaxRedirects 最多跟随的重定向次数，0表示不跟随重定向
+	MaxRedirects int
+	// CrossHostRedirects 重定向到其他主机时的处理策略
+	CrossHostRedirects CrossHostPolicy
+
+	errs []error // 配置函数产生的错误，由 NewDownloader 返回
+	pac  *PAC    // WithPAC 设置的PAC脚本，连接代理失败时改用脚本返回的下一个代理
 }
 
 // OptionFunc 配置函数
@@ -164,60 +309,59 @@ func WithResume(resume bool) OptionFunc {
 }
 
 // WithHTTPClient 设置自定义的HTTP客户端
-// 可用于配置超时、重试策略、TLS配置等
+// 可用于配置超时、重试策略、TLS配置等；WithProxy、WithTLSConfig 等配置会在客户端传输层的副本上生效，不修改该客户端
 func WithHTTPClient(client *http.Client) OptionFunc {
 	return func(o *Options) {
 		o.HTTPClient = client
 	}
 }
 
-// WithProxy 设置代理服务器
-// proxyURL 代理服务器地址，例如："http://127.0.0.1:7890" 或 "socks5://127.0.0.1:1080"
-func WithProxy(proxyURL string) OptionFunc {
+// WithHostGovernor 设置按主机限制连接数和速率的调度器
+// 同一个调度器可以在多个下载器之间共享，超出上限的分片会排队等待
+func WithHostGovernor(g *HostGovernor) OptionFunc {
 	return func(o *Options) {
-		if proxyURL == "" {
-			return
-		}
-		proxyURLParsed, err := url.Parse(proxyURL)
-		if err != nil {
-			return
-		}
-		transport := &http.Transport{
-			Proxy: http.ProxyURL(proxyURLParsed),
-		}
-		o.HTTPClient = &http.Client{
-			Transport: transport,
-		}
+		o.HostGovernor = g
 	}
 }
 
-// WithSystemProxy 使用系统代理设置
-// 会自动读取系统的 HTTP_PROXY、HTTPS_PROXY 和 NO_PROXY 环境变量
-func WithSystemProxy() OptionFunc {
+// WithMaxSize 设置允许下载的最大字节数，0表示不限制
+// 服务器返回的文件大小超过上限时不会开始下载；文件大小未知时，
+// 下载的数据超过上限会中止下载。两种情况都返回 ErrMaxSizeExceeded
+func WithMaxSize(n int64) OptionFunc {
 	return func(o *Options) {
-		transport := &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-		}
-		o.HTTPClient = &http.Client{
-			Transport: transport,
-		}
+		o.MaxSize = n
 	}
 }
 
 // Downloader 文件下载器，支持多协程并发下载和断点续传
 type Downloader struct {
 	url                string              // 下载URL
+	sources            []*source           // 下载源（多源下载时包含所有镜像）
+	srcMu              sync.Mutex          // 保护下载源的统计信息
 	concurrency        int                 // 并发数
 	resume             bool                // 是否启用断点续传
 	partDir            string              // 分片文件目录
+	parts              int                 // 分片数量
+	remote             *remoteInfo         // 最近一次探测得到的远程文件信息
+	rangeProbes        rangeProbeCache     // HEAD请求无法判断Range支持情况的主机
+	target             string              // 调用方配置的或根据服务器响应确定的目标文件路径
+	autoName           bool                // 未指定文件名，探测时根据服务器响应确定
+	outcome            Outcome             // 本次下载完成后的结果
+	result             Result              // 最近一次下载的结果
+	resultMu           sync.Mutex          // 保护下载结果和目标文件路径
+	condition          *fileMeta           // 本次条件请求使用的上次下载记录
 	sw                 *selfWriter         // 进度跟踪器
 	options            *Options            // 配置选项
 	httpClient         *http.Client        // HTTP客户端
 	stopSignal         chan struct{}       // 停止信号
-	mCancelFunc        sync.Map            // 取消函数映射表 map[string]context.CancelFunc
+	suspended          atomic.Bool         // 被任务管理器抢占而停止，不触发取消回调
+	mCancelFunc        sync.Map            // 取消函数映射表，键为分片文件名或 probeCancelKey，值为 context.CancelFunc
+	scheduler          connScheduler       // 连接调度器（由任务管理器设置）
 	onDownloadStart    func(int64, string) // 下载开始回调
+	onStartInfo        func(StartInfo)     // 下载开始回调（详细信息）
 	onDownloadFinished func(string)        // 下载完成回调
 	onDownloadCanceled func(string)        // 下载取消回调
+	onNotModified      func(string)        // 远程文件未变化回调
 }
 
 // NewDownloader 创建一个新的文件下载器实例
@@ -230,25 +374,40 @@ type Downloader struct {
 // 返回:
 //
 //	*Downloader - 配置好的下载器实例
+//	error - 代理地址无效或传输层配置无法应用时返回错误
 //
 // 示例:
 //
-//	dl := NewDownloader("https://example.com/file.zip",
+//	dl, err := NewDownloader("https://example.com/file.zip",
 //	    WithConcurrency(8),
 //	    WithResume(true))
-func NewDownloader(url string, opts ...OptionFunc) *Downloader {
-	filename := filepath.Base(url)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+func NewDownloader(url string, opts ...OptionFunc) (*Downloader, error) {
 	options := &Options{
-		Concurrency: runtime.NumCPU(),
-		BaseDir:     DefaultBaseDir,
-		FileName:    filename,
-		FilePath:    filename,
-		Resume:      true,
+		Concurrency:  runtime.NumCPU(),
+		BaseDir:      DefaultBaseDir,
+		Resume:       true,
+		MaxRedirects: DefaultMaxRedirects,
 	}
 
 	for _, opt := range opts {
 		opt(options)
 	}
+	if err := errors.Join(options.errs...); err != nil {
+		return nil, err
+	}
+
+	// 未指定文件名时先使用URL中的文件名，开始下载时再根据服务器的响应确定
+	autoName := options.FilePath == ""
+	if autoName {
+		filename := nameFromURL(url)
+		if filename == "" {
+			filename = DefaultFileName
+		}
+		options.FileName, options.FilePath = filename, filename
+	}
 
 	// 如果并发数为0，使用CPU核心数
 	if options.Concurrency == 0 {
@@ -264,16 +423,41 @@ func NewDownloader(url string, opts ...OptionFunc) *Downloader {
 		httpClient = http.DefaultClient
 	}
 
+	// 复制客户端，不修改调用方（可能共享）的客户端
+	client := *httpClient
+	httpClient = &client
+
+	// 在客户端原有传输层的副本上应用代理、TLS等配置，与选项的先后顺序无关
+	transport, err := buildTransport(httpClient.Transport, options)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Transport = transport
+
+	// 客户端自带Cookie容器时沿用，否则使用独立的内存容器，探测响应设置的Cookie会带到分片请求中
+	if options.CookieJar != nil {
+		httpClient.Jar = options.CookieJar
+	} else if httpClient.Jar == nil {
+		httpClient.Jar, _ = cookiejar.New(nil)
+	}
+	// 客户端自定义了重定向检查时以客户端为准
+	if httpClient.CheckRedirect == nil {
+		httpClient.CheckRedirect = redirectPolicy(options)
+	}
+
 	return &Downloader{
 		url:         url,
+		sources:     newSources([]string{url}),
 		concurrency: options.Concurrency,
 		resume:      options.Resume,
 		options:     options,
+		target:      options.FilePath,
+		autoName:    autoName,
 		httpClient:  httpClient,
 		sw:          sw,
 		stopSignal:  make(chan struct{}),
 		mCancelFunc: sync.Map{},
-	}
+	}, nil
 }
 
 // OnProgress 设置下载进度回调函数
@@ -282,6 +466,8 @@ func NewDownloader(url string, opts ...OptionFunc) *Downloader {
 //
 //	f - 回调函数，接收已下载字节数、总字节数和当前速率
 //
+// 文件大小未知时 total 为 UnknownSize，下载完成后会再回调一次，此时 total 等于实际大小。
+//
 // 注意: 此回调会被频繁调用，应避免执行耗时操作
 func (d *Downloader) OnProgress(f func(loaded int64, total int64, rate string)) {
 	d.sw.mu.Lock()
@@ -293,11 +479,50 @@ func (d *Downloader) OnProgress(f func(loaded int64, total int64, rate string))
 //
 // 参数:
 //
-//	f - 回调函数，接收文件总大小和文件名
+//	f - 回调函数，接收文件总大小（未知时为 UnknownSize）和文件名
 func (d *Downloader) OnDownloadStart(f func(total int64, filename string)) {
 	d.onDownloadStart = f
 }
 
+// StartInfo 下载开始时的详细信息
+type StartInfo struct {
+	// Total 文件大小，未知时为 UnknownSize
+	Total int64
+	// FilePath 目标文件路径
+	FilePath string
+	// URL 配置的下载地址
+	URL string
+	// FinalURL 探测时跟随重定向得到的地址，本次下载的所有请求都直接使用该地址
+	FinalURL string
+	// Segmented 是否分段下载
+	Segmented bool
+}
+
+// OnDownloadStartInfo 设置下载开始时的回调函数，与 OnDownloadStart 同时调用，提供更详细的信息
+//
+// 参数:
+//
+//	f - 回调函数，接收下载开始时的详细信息
+func (d *Downloader) OnDownloadStartInfo(f func(info StartInfo)) {
+	d.onStartInfo = f
+}
+
+// notifyStart 调用下载开始回调
+func (d *Downloader) notifyStart(total int64, filename string, segmented bool) {
+	if d.onDownloadStart != nil {
+		d.onDownloadStart(total, filename)
+	}
+	if d.onStartInfo != nil {
+		d.onStartInfo(StartInfo{
+			Total:     total,
+			FilePath:  filename,
+			URL:       d.url,
+			FinalURL:  d.primaryURL(),
+			Segmented: segmented,
+		})
+	}
+}
+
 // OnDownloadFinished 设置下载成功完成后的回调函数
 //
 // 参数:
@@ -318,7 +543,8 @@ func (d *Downloader) OnDownloadCanceled(f func(filename string)) {
 
 // Start 开始执行下载任务
 //
-// 如果下载器之前被停止，会自动重新初始化
+// 如果下载器之前被停止，会自动重新初始化。每次开始都会重新统计下载进度，
+// 已完成的下载器再次开始时不会累加上一次的进度
 //
 // 返回:
 //
@@ -329,6 +555,7 @@ func (d *Downloader) Start() error {
 		d.init()
 	default:
 	}
+	d.resetProgress()
 	return d.download()
 }
 
@@ -348,15 +575,20 @@ func (d *Downloader) Stop() error {
 		close(d.stopSignal)
 	}
 
-	// 取消所有正在进行的下载协程
+	d.cancelAll()
+	return nil
+}
+
+// cancelAll 取消所有正在进行的下载协程
+func (d *Downloader) cancelAll() {
 	d.mCancelFunc.Range(func(key, value interface{}) bool {
 		if cancelFunc, ok := value.(context.CancelFunc); ok {
 			cancelFunc()
 		}
+		// 分片协程可能仍在访问映射表，逐个删除而不是整体替换
+		d.mCancelFunc.Delete(key)
 		return true
 	})
-	d.mCancelFunc = sync.Map{}
-	return nil
 }
 
 // Pause 暂停下载（Stop的别名）
@@ -382,16 +614,72 @@ func (d *Downloader) Resume() error {
 	return d.Start()
 }
 
-// init 初始化下载器状态，用于重新开始下载
+// suspend 停止下载但不触发取消回调，用于任务管理器抢占运行中的任务
+func (d *Downloader) suspend() error {
+	d.suspended.Store(true)
+	return d.Stop()
+}
+
+// notifyCanceled 下载被停止时调用取消回调，被抢占的下载之后还会继续，不通知调用方
+func (d *Downloader) notifyCanceled(filename string) {
+	if d.onDownloadCanceled != nil && !d.suspended.Load() {
+		d.onDownloadCanceled(filename)
+	}
+}
+
+// init 初始化下载器状态，用于停止后重新开始下载
 func (d *Downloader) init() {
-	d.sw.mu.Lock()
-	d.sw.loaded = 0
-	d.sw.mu.Unlock()
+	d.stopSignal = make(chan struct{})
+	d.mCancelFunc = sync.Map{}
+	d.suspended.Store(false)
+}
 
+// resetProgress 清零下载进度和速率，每次开始下载前调用
+func (d *Downloader) resetProgress() {
+	d.sw.reset()
 	atomic.StoreInt64(&d.sw.accPacketSize, 0)
 	d.sw.rate.Store("0.00 MB/s")
-	d.stopSignal = make(chan struct{})
-	d.mCancelFunc = sync.Map{}
+}
+
+// isStopped 判断下载器是否已被停止
+func (d *Downloader) isStopped() bool {
+	select {
+	case <-d.stopSignal:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquireConn 获取一个到host的连接配额
+//
+// 先从任务管理器获取配额，再从主机调度器获取该主机的空闲连接，未设置时不做限制
+func (d *Downloader) acquireConn(ctx context.Context, host string, revocable bool) (*connLease, error) {
+	lease := &connLease{}
+	if d.scheduler != nil {
+		var err error
+		if lease, err = d.scheduler.acquire(ctx, revocable); err != nil {
+			return nil, err
+		}
+	}
+
+	if g := d.options.HostGovernor; g != nil {
+		release, err := g.acquire(ctx, host)
+		if err != nil {
+			lease.release()
+			return nil, err
+		}
+		lease.releaseHost = release
+	}
+	return lease, nil
+}
+
+// bodyReader 按主机调度器的速率限制包装响应体
+func (d *Downloader) bodyReader(ctx context.Context, host string, body io.Reader) io.Reader {
+	if g := d.options.HostGovernor; g != nil {
+		return g.reader(ctx, host, body)
+	}
+	return body
 }
 
 // download 执行实际的下载逻辑，根据服务器支持情况选择单线程或多线程下载
@@ -400,26 +688,181 @@ func (d *Downloader) download() error {
 		return ErrInvalidURL
 	}
 
-	// 发送HEAD请求检查服务器是否支持Range请求
-	resp, err := d.httpClient.Head(d.url)
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+	// 文件名取决于服务器的响应时，需要先探测再处理已存在的目标文件
+	var info *remoteInfo
+	var err error
+	if d.autoName {
+		if info, err = d.probe(); err != nil {
+			return fmt.Errorf("failed to get file info: %w", err)
+		}
+		if err = d.resolveName(info); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
+
+	// 按策略处理已存在的目标文件
+	skip, err := d.prepareTarget()
+	if err != nil || skip {
+		return err
+	}
+	d.loadCondition()
+
+	// 发送HEAD请求检查服务器是否支持Range请求，需要条件请求时重新探测
+	if info == nil || d.condition != nil {
+		if info, err = d.probe(); err != nil {
+			return fmt.Errorf("failed to get file info: %w", err)
+		}
+	}
+	if info.status == http.StatusNotModified {
+		// 只有下载器发送了条件请求时，304才表示本地文件没有变化
+		if d.condition == nil {
+			return fmt.Errorf("failed to get file info: unexpected status code %d", info.status)
+		}
+		return d.notModified(info)
+	}
+	if d.sameAsRemote(info) {
+		d.setResult(OutcomeSkipped)
+		return nil
+	}
+	if want := d.options.ExpectedSize; want > 0 && info.size >= 0 && info.size != want {
+		return fmt.Errorf("%w: remote size %d, expected %d", ErrSourceMismatch, info.size, want)
+	}
+	if limit := d.options.MaxSize; limit > 0 && info.size > limit {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMaxSizeExceeded, info.size, limit)
+	}
+	d.remote = info
 
 	// 启动速率计算协程
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go d.sw.calcRate(ctx)
 
-	// 检查服务器是否支持分段下载
-	if resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "bytes" {
-		return d.multiDownload(resp.ContentLength)
+	// 检查服务器是否支持分段下载，文件大小未知或为空时只能单线程下载
+	if info.acceptRanges && info.size > 0 {
+		return d.multiDownload(info.size)
 	}
 
 	return d.singleDownload()
 }
 
+// remoteInfo 探测得到的远程文件信息
+type remoteInfo struct {
+	status       int    // HEAD响应状态码
+	size         int64  // 文件大小，未知时为-1
+	etag         string // ETag
+	lastModified string // Last-Modified
+	acceptRanges bool   // 是否支持分段下载
+	filename     string // Content-Disposition 中的文件名
+	finalURL     string // 重定向之后的地址
+}
+
+// validator 返回可用于 If-Range 的校验值，优先使用强ETag
+func (info *remoteInfo) validator() string {
+	if info == nil {
+		return ""
+	}
+	if info.etag != "" && !strings.HasPrefix(info.etag, "W/") {
+		return info.etag
+	}
+	return info.lastModified
+}
+
+// probeCancelKey 探测请求在取消函数映射表中的键
+type probeCancelKey struct{}
+
+// probe 探测远程文件信息，多源下载时会探测并校验所有下载源
+//
+// 与分片下载一样，等待连接配额和探测请求都可以被 Stop 中断
+func (d *Downloader) probe() (*remoteInfo, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.mCancelFunc.Store(probeCancelKey{}, cancel)
+	defer d.mCancelFunc.Delete(probeCancelKey{})
+
+	// 注册取消函数之前下载器可能已被停止
+	if d.isStopped() {
+		cancel()
+	}
+
+	d.resetSources()
+	if len(d.sources) > 1 {
+		return d.probeSources(ctx)
+	}
+	info, err := d.probeURL(ctx, d.url)
+	if err == nil {
+		d.pinSource(d.sources[0], info)
+	}
+	return info, err
+}
+
+// probeURL 获取单个地址的文件信息
+//
+// 先发送HEAD请求；HEAD响应无法确定文件大小或Range支持情况时，
+// 再用 Range: bytes=0-0 的GET请求探测，并记住该主机以便之后直接探测
+func (d *Downloader) probeURL(ctx context.Context, rawURL string) (*remoteInfo, error) {
+	host := hostOf(rawURL)
+	if d.rangeProbes.has(host) {
+		return d.probeRange(ctx, rawURL)
+	}
+
+	resp, err := d.head(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &remoteInfo{
+		status:       resp.StatusCode,
+		size:         resp.ContentLength,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		acceptRanges: resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "bytes",
+		filename:     nameFromDisposition(resp.Header.Get("Content-Disposition")),
+		finalURL:     resp.Request.URL.String(),
+	}
+	if info.status == http.StatusNotModified {
+		return info, nil
+	}
+	if headUseful(info, resp.Header.Get("Accept-Ranges")) {
+		return info, nil
+	}
+
+	ranged, err := d.probeRange(ctx, rawURL)
+	if err == nil && ranged.status == http.StatusNotModified {
+		return ranged, nil
+	}
+	if err != nil || ranged.status != http.StatusOK {
+		// GET探测失败时沿用HEAD的结果
+		if info.status == http.StatusOK {
+			return info, nil
+		}
+		return ranged, err
+	}
+	if ranged.acceptRanges {
+		d.rangeProbes.add(host)
+	}
+	if ranged.etag == "" {
+		ranged.etag = info.etag
+	}
+	if ranged.lastModified == "" {
+		ranged.lastModified = info.lastModified
+	}
+	if ranged.filename == "" {
+		ranged.filename = info.filename
+	}
+	return ranged, nil
+}
+
+// head 发送HEAD请求
+func (d *Downloader) head(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := d.newRequest(ctx, http.MethodHead, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	d.setConditionHeaders(req)
+	return d.probeDo(req)
+}
+
 // multiDownload 使用多协程并发下载文件
 func (d *Downloader) multiDownload(contentLen int64) (err error) {
 	if contentLen <= 0 {
@@ -427,63 +870,98 @@ func (d *Downloader) multiDownload(contentLen int64) (err error) {
 	}
 
 	filename := d.options.FilePath
+	partDir := d.getPartDir(d.options.FileName)
+
+	// 合并时分片文件和目标文件同时存在，断点续传时已下载的分片不再计入
+	existing := int64(0)
+	if d.resume {
+		existing = dirSize(partDir)
+	}
+	if err = d.checkSpace(
+		spaceNeed{dir: partDir, bytes: contentLen - existing},
+		spaceNeed{dir: filepath.Dir(filename), bytes: contentLen},
+	); err != nil {
+		return err
+	}
 
 	d.sw.mu.Lock()
 	d.sw.total = contentLen
 	d.sw.mu.Unlock()
 
-	if d.onDownloadStart != nil {
-		d.onDownloadStart(contentLen, filename)
-	}
+	d.notifyStart(contentLen, filename, true)
 
-	partSize := contentLen / int64(d.concurrency)
-	partDir := d.getPartDir(d.options.FileName)
 	if err = os.MkdirAll(partDir, DirPerm); err != nil {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	d.partDir = partDir
 
+	if p := d.options.Pieces; p != nil && len(p.Hashes) > 0 {
+		if err = p.check(contentLen); err != nil {
+			return err
+		}
+	}
+
+	segments := d.plan(contentLen)
+	queue := make(chan segment)
+
 	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var partErr error
 
-	// 启动多个协程并发下载
-	rangeStart := int64(0)
-	for i := 0; i < d.concurrency; i++ {
-		select {
-		case <-d.stopSignal:
-			return nil
-		default:
-		}
+	// 服务器忽略Range请求时放弃分段下载
+	abort := make(chan struct{})
+	var abortOnce sync.Once
 
+	// 启动多个协程并发下载，每个协程依次领取分片
+	for w := 0; w < min(d.concurrency, len(segments)); w++ {
 		wg.Add(1)
-		go func(i int, rangeStart int64) {
+		go func() {
 			defer wg.Done()
 
-			// 计算当前分片的下载范围
-			rangeEnd := rangeStart + partSize
-			if i == d.concurrency-1 {
-				rangeEnd = contentLen // 最后一个分片下载到文件末尾
-			}
+			for seg := range queue {
+				select {
+				case <-abort:
+					continue
+				default:
+				}
 
-			// 如果启用断点续传，计算已下载的大小
-			var downloaded int64
-			if d.resume {
-				partFileName := d.getPartFilename(filename, i)
-				if content, err := os.ReadFile(partFileName); err == nil {
-					downloaded = int64(len(content))
-					_, _ = d.sw.Write(content)
+				// 如果启用断点续传，计算已下载的大小
+				var downloaded int64
+				if d.resume {
+					partFileName := d.getPartFilename(d.options.FileName, seg.index)
+					if info, err := os.Stat(partFileName); err == nil {
+						downloaded = info.Size()
+						d.sw.restore(downloaded)
+					}
 				}
-			}
 
-			// 下载分片
-			if err := d.downloadPartial(rangeStart+downloaded, rangeEnd, i); err != nil {
-				// 错误已在downloadPartial中处理
-				return
+				// 下载分片
+				if err := d.downloadPartial(seg, downloaded); err != nil {
+					errOnce.Do(func() { partErr = err })
+					if errors.Is(err, ErrRangeIgnored) {
+						abortOnce.Do(func() {
+							close(abort)
+							d.cancelAll()
+						})
+					}
+				}
 			}
-		}(i, rangeStart)
+		}()
+	}
 
-		rangeStart += partSize
+	// 分发分片，下载器停止后不再分发
+dispatch:
+	for _, seg := range segments {
+		select {
+		case <-d.stopSignal:
+			break dispatch
+		case <-abort:
+			break dispatch
+		case queue <- seg:
+		}
 	}
+	close(queue)
 
 	// 等待所有分片下载完成
 	wg.Wait()
@@ -491,17 +969,38 @@ func (d *Downloader) multiDownload(contentLen int64) (err error) {
 	// 检查是否被取消
 	select {
 	case <-d.stopSignal:
-		if d.onDownloadCanceled != nil {
-			d.onDownloadCanceled(filename)
-		}
+		d.notifyCanceled(filename)
 		return nil
 	default:
 	}
 
-	// 合并所有分片文件
-	if err = d.merge(); err != nil {
+	select {
+	case <-abort:
+		// 服务器实际不支持Range请求，丢弃已下载的分片，改为单线程下载（会再次触发开始回调）
+		_ = os.RemoveAll(partDir)
+		_ = removeIfEmpty(d.options.BaseDir)
+		d.sw.reset()
+		return d.singleDownload()
+	default:
+	}
+
+	if partErr != nil {
+		return partErr
+	}
+
+	// 合并所有分片文件到临时文件，校验通过后再重命名为目标文件
+	tmp := d.tempPath()
+	if err = d.merge(tmp); err != nil {
 		return fmt.Errorf("failed to merge parts: %w", err)
 	}
+	// 分块哈希已在各分片下载完成时校验过，这里只校验整个文件
+	if err = d.verifyChecksum(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = d.finalize(tmp); err != nil {
+		return err
+	}
 
 	// 删除临时目录
 	_ = os.RemoveAll(partDir)
@@ -514,45 +1013,80 @@ func (d *Downloader) multiDownload(contentLen int64) (err error) {
 	return nil
 }
 
-// downloadPartial 下载文件的指定分片
-func (d *Downloader) downloadPartial(rangeStart, rangeEnd int64, i int) error {
-	if rangeStart >= rangeEnd {
+// segment 分段下载中的一个分片，范围为[start, end)
+type segment struct {
+	index int
+	start int64
+	end   int64
+}
+
+// plan 将文件划分为若干分片
+//
+// 单源下载时分片数等于并发数；多源下载时划分得更细，
+// 使速度快的下载源能够领取更多分片。
+// 设置了分块哈希时，分片边界与块边界对齐，以便每个块都完整地落在一个分片中
+func (d *Downloader) plan(contentLen int64) []segment {
+	count := d.concurrency
+	if len(d.sources) > 1 {
+		count *= multiSourceSplit
+	}
+	// 文件过小时避免出现空分片
+	if int64(count) > contentLen {
+		count = int(contentLen)
+	}
+
+	partSize := contentLen / int64(count)
+	if p := d.options.Pieces; p != nil && p.Length > 0 {
+		pieces := (contentLen + p.Length - 1) / p.Length
+		perPart := (pieces + int64(count) - 1) / int64(count)
+		partSize = perPart * p.Length
+		count = int((contentLen + partSize - 1) / partSize)
+	}
+
+	segments := make([]segment, count)
+	for i := range segments {
+		start := int64(i) * partSize
+		end := start + partSize
+		if i == count-1 {
+			end = contentLen // 最后一个分片下载到文件末尾
+		}
+		segments[i] = segment{index: i, start: start, end: end}
+	}
+
+	d.parts = count
+	return segments
+}
+
+// downloadPartial 下载文件的指定分片，downloaded 为断点续传时分片文件中已有的字节数
+//
+// 设置了分块哈希时，每下载完一块立即校验，并只重新下载损坏的块
+func (d *Downloader) downloadPartial(seg segment, downloaded int64) error {
+	i := seg.index
+	if seg.start+downloaded >= seg.end && d.options.Pieces == nil {
 		return nil
 	}
 
-	url := d.url
 	filename := d.options.FileName
 
 	partFilename := d.getPartFilename(filename, i)
 
 	// 创建可取消的上下文
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	d.mCancelFunc.Store(partFilename, cancel)
 	defer d.mCancelFunc.Delete(partFilename)
 
-	// 创建Range请求
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// 注意：Range的end是inclusive的，所以需要减1
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rangeStart, rangeEnd-1))
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download part %d: %w", i, err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("unexpected status code %d for part %d", resp.StatusCode, i)
+	// 注册取消函数之前下载器可能已被停止
+	if d.isStopped() {
+		cancel()
 	}
 
 	// 打开或创建分片文件
 	flags := os.O_CREATE | os.O_WRONLY
 	if d.resume {
 		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
 	}
 
 	partFile, err := os.OpenFile(partFilename, flags, FilePerm)
@@ -561,19 +1095,125 @@ func (d *Downloader) downloadPartial(rangeStart, rangeEnd int64, i int) error {
 	}
 	defer partFile.Close()
 
+	return d.fetchPieces(ctx, seg, seg.start+downloaded, partFile, partFilename)
+}
+
+// fetch 下载[rangeStart, rangeEnd)范围的数据并写入w
+//
+// 下载前需要先获取连接配额；如果配额被调度器收回，
+// 会从已写入的位置重新排队，继续下载剩余部分。
+// 多源下载时，当前下载源出错会切换到其他下载源从断点继续
+func (d *Downloader) fetch(ctx context.Context, rangeStart, rangeEnd int64, i int, w io.Writer) error {
+	if rangeStart >= rangeEnd {
+		return nil
+	}
+
+	var lastErr error
+	exclude := make(map[*source]bool)
+	for {
+		src := d.pickSource(exclude)
+		if src == nil {
+			if lastErr == nil {
+				lastErr = fmt.Errorf("no available source for part %d", i)
+			}
+			return lastErr
+		}
+
+		// 连接数和速度都按跟随重定向之后实际请求的主机限制
+		target := src.target()
+		lease, err := d.acquireConn(ctx, hostOf(target), true)
+		if err != nil {
+			d.reportSource(src, 0, 0, false)
+			return fmt.Errorf("failed to acquire connection for part %d: %w", i, err)
+		}
+
+		begin := time.Now()
+		written, err := d.fetchRange(ctx, lease, target, rangeStart, rangeEnd, i, w)
+		lease.release()
+		rangeStart += written
+
+		revoked := err != nil && lease.isRevoked() && ctx.Err() == nil
+		d.reportSource(src, written, time.Since(begin), err != nil && !revoked && ctx.Err() == nil)
+
+		switch {
+		case err == nil, ctx.Err() != nil:
+			return err
+		case revoked:
+			// 连接配额被收回，从已下载的位置重新排队
+			continue
+		}
+
+		// 忽略Range请求的下载源无法参与分段下载
+		if errors.Is(err, ErrRangeIgnored) {
+			d.disableSource(src)
+		}
+
+		// 当前下载源出错，换一个下载源从已下载的位置继续
+		lastErr = err
+		exclude[src] = true
+	}
+}
+
+// fetchRange 请求[rangeStart, rangeEnd)范围的数据并写入w，返回实际写入的字节数
+func (d *Downloader) fetchRange(ctx context.Context, lease *connLease, rawURL string, rangeStart, rangeEnd int64, i int, w io.Writer) (int64, error) {
+	if rangeStart >= rangeEnd {
+		return 0, nil
+	}
+
+	// 配额被收回时中断当前请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if lease.revoked != nil {
+		go func() {
+			select {
+			case <-lease.revoked:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	// 创建Range请求
+	req, err := d.newRequest(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return 0, err
+	}
+
+	// 注意：Range的end是inclusive的，所以需要减1
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rangeStart, rangeEnd-1))
+	resp, err := d.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download part %d: %w", i, err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态，206响应的范围必须与请求的范围一致
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		cr := resp.Header.Get("Content-Range")
+		start, end, _, err := parseContentRange(cr)
+		if err != nil || start != rangeStart || end != rangeEnd-1 {
+			return 0, fmt.Errorf("unexpected Content-Range %q for part %d, requested bytes %d-%d", cr, i, rangeStart, rangeEnd-1)
+		}
+	case http.StatusOK:
+		// 服务器忽略了Range请求，响应体是整个文件
+		return 0, fmt.Errorf("%w: part %d", ErrRangeIgnored, i)
+	default:
+		return 0, fmt.Errorf("unexpected status code %d for part %d", resp.StatusCode, i)
+	}
+
 	// 使用缓冲区复制数据
 	buf := make([]byte, DefaultBufferSize)
-	_, err = io.CopyBuffer(io.MultiWriter(partFile, d.sw), resp.Body, buf)
+	body := d.bodyReader(ctx, hostOf(rawURL), resp.Body)
+	written, err := io.CopyBuffer(io.MultiWriter(w, d.sw), body, buf)
 	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to write part %d: %w", i, err)
+		return written, fmt.Errorf("failed to write part %d: %w", i, err)
 	}
-	return nil
+	return written, nil
 }
 
-// merge 合并所有分片文件为最终文件
-func (d *Downloader) merge() error {
-	filename := d.options.FilePath
-
+// merge 按顺序合并所有分片文件，写入filename并同步到磁盘
+func (d *Downloader) merge(filename string) error {
 	// 确保目标目录存在
 	if err := os.MkdirAll(filepath.Dir(filename), DirPerm); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
@@ -587,7 +1227,7 @@ func (d *Downloader) merge() error {
 	defer destFile.Close()
 
 	// 按顺序合并所有分片
-	for i := 0; i < d.concurrency; i++ {
+	for i := 0; i < d.parts; i++ {
 		partFileName := d.getPartFilename(d.options.FileName, i)
 
 		partFile, err := os.Open(partFileName)
@@ -609,89 +1249,196 @@ func (d *Downloader) merge() error {
 		}
 	}
 
-	return nil
+	return syncClose(destFile)
 }
 
 // getPartDir 获取分片文件的存储目录
 func (d *Downloader) getPartDir(filename string) string {
-	return filepath.Join(d.options.BaseDir, filename)
+	return partDirOf(d.options.BaseDir, filename)
 }
 
 // getPartFilename 获取指定分片的文件名
 func (d *Downloader) getPartFilename(filename string, partNum int) string {
-	return filepath.Join(d.partDir, fmt.Sprintf("%s_%d", filename, partNum))
+	return filepath.Join(d.partDir, fmt.Sprintf("%s_%d", partName(filename), partNum))
 }
 
 // singleDownload 使用单线程下载文件（当服务器不支持Range请求时）
 func (d *Downloader) singleDownload() error {
-	url := d.url
+	url := d.primaryURL()
 	filename := d.options.FilePath
+	// 数据先写入临时文件，完成后再重命名为目标文件
+	tmp := d.tempPath()
 
 	// 创建可取消的上下文
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	d.mCancelFunc.Store(filename, cancel)
 	defer d.mCancelFunc.Delete(filename)
 
-	// 创建GET请求
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	// 注册取消函数之前下载器可能已被停止
+	if d.isStopped() {
+		cancel()
+	}
+
+	// 单线程下载无法从中断处继续，获取的连接配额不允许被收回
+	lease, err := d.acquireConn(ctx, hostOf(url), false)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer lease.release()
+
+	// 如果启用断点续传，从临时文件的末尾继续下载
+	var offset int64
+	if d.resume {
+		if info, err := os.Stat(tmp); err == nil && info.Mode().IsRegular() {
+			offset = info.Size()
+		}
 	}
 
-	resp, err := d.httpClient.Do(req)
+	resp, offset, err := d.openStream(ctx, url, offset)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	// 重新下载完整文件时以本次响应的修改时间为准
+	if lm := resp.Header.Get("Last-Modified"); lm != "" && resp.StatusCode == http.StatusOK {
+		d.remote.lastModified = lm
 	}
 
 	contentLen := resp.ContentLength
+	if contentLen >= 0 {
+		contentLen += offset
+	}
+	maxSize := d.options.MaxSize
+	if maxSize > 0 && contentLen > maxSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMaxSizeExceeded, contentLen, maxSize)
+	}
+	if err = d.checkSpace(spaceNeed{dir: filepath.Dir(filename), bytes: resp.ContentLength}); err != nil {
+		return err
+	}
 	d.sw.mu.Lock()
 	d.sw.total = contentLen
 	d.sw.mu.Unlock()
 
-	if d.onDownloadStart != nil {
-		d.onDownloadStart(contentLen, filename)
-	}
+	d.notifyStart(contentLen, filename, false)
+	d.sw.restore(offset)
 
 	// 确保目标目录存在
 	if err := os.MkdirAll(filepath.Dir(filename), DirPerm); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	// 创建目标文件
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerm)
+	// 创建目标文件，续传时追加到已有内容之后
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(tmp, flags, FilePerm)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer f.Close()
 
-	// 下载并写入文件
-	buf := make([]byte, DefaultBufferSize)
-	_, err = io.CopyBuffer(io.MultiWriter(f, d.sw), resp.Body, buf)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to write file: %w", err)
+	// 下载并写入文件，设置了大小上限时最多多读一个字节用于判断是否超限
+	body := d.bodyReader(ctx, hostOf(url), resp.Body)
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize-offset+1)
 	}
+	buf := make([]byte, DefaultBufferSize)
+	written, err := io.CopyBuffer(io.MultiWriter(f, d.sw), body, buf)
 
-	// 检查是否被取消
+	// 检查是否被取消，取消时读取响应体也会返回错误
 	select {
 	case <-d.stopSignal:
-		if d.onDownloadCanceled != nil {
-			d.onDownloadCanceled(filename)
-		}
+		d.notifyCanceled(filename)
 		return nil
 	default:
-		if d.onDownloadFinished != nil {
-			d.onDownloadFinished(filename)
-		}
+	}
+
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if maxSize > 0 && offset+written > maxSize {
+		f.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%w: more than %d bytes", ErrMaxSizeExceeded, maxSize)
+	}
+	if err = syncClose(f); err != nil {
+		return err
+	}
+	if err = d.verify(tmp); err != nil {
+		// 校验失败的数据不能用于续传
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = d.finalize(tmp); err != nil {
+		return err
+	}
+	if contentLen == UnknownSize {
+		d.sw.complete()
+	}
+	if d.onDownloadFinished != nil {
+		d.onDownloadFinished(filename)
 	}
 
 	return nil
 }
 
+// openStream 发送单线程下载的GET请求，offset大于0时尝试从offset处继续
+//
+// 续传请求带有 If-Range，文件在服务器上已变化时服务器会返回完整文件；
+// 服务器拒绝Range请求时重新请求完整文件。返回响应和实际的起始位置
+func (d *Downloader) openStream(ctx context.Context, rawURL string, offset int64) (*http.Response, int64, error) {
+	if offset > 0 {
+		req, err := d.newRequest(ctx, http.MethodGet, rawURL)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if v := d.remote.validator(); v != "" {
+			req.Header.Set("If-Range", v)
+		}
+
+		resp, err := d.do(req)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to download file: %w", err)
+		}
+		cr := resp.Header.Get("Content-Range")
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			if start, _, _, err := parseContentRange(cr); err == nil && start == offset {
+				return resp, offset, nil
+			}
+		case http.StatusOK:
+			// 服务器忽略了Range或文件已变化，响应体是完整文件
+			return resp, 0, nil
+		case http.StatusRequestedRangeNotSatisfiable:
+			// 本地文件已经完整
+			if total, err := strconv.ParseInt(strings.TrimPrefix(cr, "bytes */"), 10, 64); err == nil && total == offset {
+				resp.Body.Close()
+				resp.Body, resp.ContentLength = http.NoBody, 0
+				return resp, offset, nil
+			}
+		}
+		resp.Body.Close()
+	}
+
+	// 从头开始下载
+	req, err := d.newRequest(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := d.do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp, 0, nil
+}
+
 // removeIfEmpty 判断文件夹是否为空，如果是则删除
 func removeIfEmpty(dirPath string) error {
 	// 1. 打开目录
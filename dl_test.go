@@ -2,6 +2,7 @@ package dl
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -49,6 +50,7 @@ func createTestServer(size int64, supportRange bool) *httptest.Server {
 			}
 
 			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", end-start+1))
 			w.WriteHeader(http.StatusPartialContent)
 			w.Write(data[start : end+1])
 		} else {
@@ -89,11 +91,24 @@ func createSlowTestServer(size int64) *httptest.Server {
 	}))
 }
 
-// cleanupTestFiles 清理测试文件
-func cleanupTestFiles(paths ...string) {
-	for _, path := range paths {
-		os.RemoveAll(path)
+// newTestDownloader 创建下载器，失败时终止测试
+func newTestDownloader(t testing.TB, url string, opts ...OptionFunc) *Downloader {
+	t.Helper()
+	d, err := NewDownloader(url, opts...)
+	if err != nil {
+		t.Fatalf("NewDownloader() error = %v", err)
+	}
+	return d
+}
+
+// newTestMultiSourceDownloader 创建多源下载器，失败时终止测试
+func newTestMultiSourceDownloader(t testing.TB, urls []string, opts ...OptionFunc) *Downloader {
+	t.Helper()
+	d, err := NewMultiSourceDownloader(urls, opts...)
+	if err != nil {
+		t.Fatalf("NewMultiSourceDownloader() error = %v", err)
 	}
+	return d
 }
 
 // TestNewDownloader 测试下载器的创建
@@ -102,14 +117,13 @@ func TestNewDownloader(t *testing.T) {
 		name    string
 		url     string
 		opts    []OptionFunc
-		wantNil bool
+		wantErr error
 		checkFn func(*testing.T, *Downloader)
 	}{
 		{
-			name:    "默认配置",
-			url:     "https://example.com/file.txt",
-			opts:    nil,
-			wantNil: false,
+			name: "默认配置",
+			url:  "https://example.com/file.txt",
+			opts: nil,
 			checkFn: func(t *testing.T, d *Downloader) {
 				if d.url != "https://example.com/file.txt" {
 					t.Errorf("url = %v, want %v", d.url, "https://example.com/file.txt")
@@ -137,7 +151,6 @@ func TestNewDownloader(t *testing.T) {
 				WithConcurrency(4),
 				WithResume(false),
 			},
-			wantNil: false,
 			checkFn: func(t *testing.T, d *Downloader) {
 				if d.options.FileName != "custom.zip" {
 					t.Errorf("filename = %v, want %v", d.options.FileName, "custom.zip")
@@ -153,14 +166,25 @@ func TestNewDownloader(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:    "无效代理地址",
+			url:     "https://example.com/file.txt",
+			opts:    []OptionFunc{WithProxy("ftp://proxy.example.com")},
+			wantErr: ErrInvalidProxy,
+		},
+		{
+			name:    "自定义传输层",
+			url:     "https://example.com/file.txt",
+			opts:    []OptionFunc{WithHTTPClient(&http.Client{Transport: roundTripFunc(nil)}), WithConnectTimeout(time.Second)},
+			wantErr: ErrCustomTransport,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := NewDownloader(tt.url, tt.opts...)
-			if (d == nil) != tt.wantNil {
-				t.Errorf("NewDownloader() = %v, wantNil %v", d, tt.wantNil)
-				return
+			d, err := NewDownloader(tt.url, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewDownloader() error = %v, want %v", err, tt.wantErr)
 			}
 			if tt.checkFn != nil && d != nil {
 				tt.checkFn(t, d)
@@ -254,11 +278,12 @@ func TestDownloadSingle(t *testing.T) {
 	server := createTestServer(size, false)
 	defer server.Close()
 
-	tmpFile := "test_single_download.txt"
-	defer cleanupTestFiles(tmpFile)
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "test_single_download.txt")
 
-	d := NewDownloader(server.URL,
+	d := newTestDownloader(t, server.URL,
 		WithFileName(tmpFile),
+		WithBaseDir(filepath.Join(dir, "cache")),
 		WithConcurrency(4),
 	)
 
@@ -311,17 +336,172 @@ func TestDownloadSingle(t *testing.T) {
 	}
 }
 
+// countingWriter 记录成功响应中写入响应体的字节数
+type countingWriter struct {
+	http.ResponseWriter
+	n      *atomic.Int64
+	failed bool
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.failed = code >= http.StatusBadRequest
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if !w.failed {
+		w.n.Add(int64(len(p)))
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// TestDownloadSingleResume 测试单线程下载从已有文件的末尾继续
+func TestDownloadSingleResume(t *testing.T) {
+	data := testData(32 * 1024)
+	half := int64(len(data) / 2)
+
+	tests := []struct {
+		name       string
+		existing   int64 // 已下载的字节数
+		ignore     bool  // 服务器忽略Range请求
+		wantServed int64
+	}{
+		{"resume", half, false, int64(len(data)) - half},
+		{"range ignored", half, true, int64(len(data))},
+		{"already complete", int64(len(data)), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				if r.Method == http.MethodHead {
+					// 声明不支持分段下载，走单线程下载
+					w.Header().Set("Accept-Ranges", "none")
+					w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+					return
+				}
+				if r.Header.Get("If-Range") != `"v1"` {
+					r.Header.Del("Range")
+				}
+				if tt.ignore {
+					r.Header.Del("Range")
+				}
+				http.ServeContent(&countingWriter{ResponseWriter: w, n: &served}, r, "", time.Time{}, strings.NewReader(string(data)))
+			}))
+			defer server.Close()
+
+			target := filepath.Join(t.TempDir(), "resumed.bin")
+			os.WriteFile(target+PartSuffix, data[:tt.existing], FilePerm)
+
+			var last int64
+			d := newTestDownloader(t, server.URL, WithFileName(target))
+			d.OnProgress(func(loaded, total int64, rate string) {
+				last = loaded
+			})
+			if err := d.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			got, err := os.ReadFile(target)
+			if err != nil || string(got) != string(data) {
+				t.Fatalf("downloaded file mismatch (%d bytes), err = %v", len(got), err)
+			}
+			if n := served.Load(); n != tt.wantServed {
+				t.Errorf("served %d bytes, want %d", n, tt.wantServed)
+			}
+			if tt.wantServed > 0 && last != int64(len(data)) {
+				t.Errorf("final progress = %d, want %d", last, len(data))
+			}
+		})
+	}
+}
+
+// createChunkedTestServer 创建一个不返回文件大小、使用分块传输编码的测试服务器
+func createChunkedTestServer(data []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		for off := 0; off < len(data); off += 4096 {
+			w.Write(data[off:min(off+4096, len(data))])
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+// TestDownloadUnknownSize 测试文件大小未知时的进度回调和大小上限
+func TestDownloadUnknownSize(t *testing.T) {
+	data := testData(40 * 1024)
+	server := createChunkedTestServer(data)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		wantErr error
+	}{
+		{"no limit", 0, nil},
+		{"within limit", int64(len(data)), nil},
+		{"exceeds limit", 16 * 1024, ErrMaxSizeExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "chunked.bin")
+			d := newTestDownloader(t, server.URL, WithFileName(target), WithMaxSize(tt.maxSize))
+
+			var startTotal int64
+			var totals []int64
+			d.OnDownloadStart(func(total int64, filename string) {
+				startTotal = total
+			})
+			d.OnProgress(func(loaded, total int64, rate string) {
+				totals = append(totals, total)
+			})
+
+			err := d.Start()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			if startTotal != UnknownSize {
+				t.Errorf("OnDownloadStart total = %d, want %d", startTotal, UnknownSize)
+			}
+			if tt.wantErr != nil {
+				for _, path := range []string{target, target + PartSuffix} {
+					if _, err := os.Stat(path); !os.IsNotExist(err) {
+						t.Errorf("oversized file %s was not removed, err = %v", path, err)
+					}
+				}
+				return
+			}
+
+			if len(totals) < 2 || totals[0] != UnknownSize {
+				t.Fatalf("progress totals = %v, want indeterminate totals first", totals)
+			}
+			if last := totals[len(totals)-1]; last != int64(len(data)) {
+				t.Errorf("final progress total = %d, want %d", last, len(data))
+			}
+			got, err := os.ReadFile(target)
+			if err != nil || string(got) != string(data) {
+				t.Errorf("downloaded file mismatch, err = %v", err)
+			}
+		})
+	}
+}
+
 // TestDownloadMulti 测试多线程下载
 func TestDownloadMulti(t *testing.T) {
 	size := int64(1024 * 100) // 100KB
 	server := createTestServer(size, true)
 	defer server.Close()
 
-	tmpFile := "test_multi_download.txt"
-	cacheDir := "test_cache_multi"
-	defer cleanupTestFiles(tmpFile, cacheDir)
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "test_multi_download.txt")
+	cacheDir := filepath.Join(dir, "cache")
 
-	d := NewDownloader(server.URL,
+	d := newTestDownloader(t, server.URL,
 		WithFileName(tmpFile),
 		WithBaseDir(cacheDir),
 		WithConcurrency(4),
@@ -358,11 +538,11 @@ func TestDownloadStop(t *testing.T) {
 	server := createSlowTestServer(size)
 	defer server.Close()
 
-	tmpFile := "test_stop_download.txt"
-	cacheDir := "test_cache_stop"
-	defer cleanupTestFiles(tmpFile, cacheDir)
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "test_stop_download.txt")
+	cacheDir := filepath.Join(dir, "cache")
 
-	d := NewDownloader(server.URL,
+	d := newTestDownloader(t, server.URL,
 		WithFileName(tmpFile),
 		WithBaseDir(cacheDir),
 		WithConcurrency(2),
@@ -409,7 +589,7 @@ func TestDownloadStop(t *testing.T) {
 
 // TestInvalidURL 测试无效URL
 func TestInvalidURL(t *testing.T) {
-	d := NewDownloader("",
+	d := newTestDownloader(t, "",
 		WithFileName("test.txt"),
 	)
 
@@ -421,7 +601,7 @@ func TestInvalidURL(t *testing.T) {
 
 // TestDownloadError 测试下载错误处理
 func TestDownloadError(t *testing.T) {
-	d := NewDownloader("http://localhost:99999/nonexistent",
+	d := newTestDownloader(t, "http://localhost:99999/nonexistent",
 		WithFileName("test.txt"),
 	)
 
@@ -438,6 +618,7 @@ func TestConcurrentDownloads(t *testing.T) {
 	defer server.Close()
 
 	const numDownloads = 3
+	dir := t.TempDir()
 	var wg sync.WaitGroup
 	errors := make(chan error, numDownloads)
 
@@ -446,11 +627,11 @@ func TestConcurrentDownloads(t *testing.T) {
 		go func(index int) {
 			defer wg.Done()
 
-			tmpFile := fmt.Sprintf("test_concurrent_%d.txt", index)
-			defer cleanupTestFiles(tmpFile)
+			tmpFile := filepath.Join(dir, fmt.Sprintf("test_concurrent_%d.txt", index))
 
-			d := NewDownloader(server.URL,
+			d := newTestDownloader(t, server.URL,
 				WithFileName(tmpFile),
+				WithBaseDir(filepath.Join(dir, fmt.Sprintf("cache_%d", index))),
 				WithConcurrency(2),
 			)
 
@@ -483,7 +664,7 @@ func TestConcurrentDownloads(t *testing.T) {
 
 // TestGetPartFilename 测试分片文件名生成
 func TestGetPartFilename(t *testing.T) {
-	d := NewDownloader("https://example.com/file.txt",
+	d := newTestDownloader(t, "https://example.com/file.txt",
 		WithBaseDir("test_cache"),
 	)
 	d.partDir = filepath.Join("test_cache", "file.txt")
@@ -535,7 +716,7 @@ func TestOptionsValidation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := NewDownloader("https://example.com/test.txt", tt.opts...)
+			d := newTestDownloader(t, "https://example.com/test.txt", tt.opts...)
 			if !tt.want(d) {
 				t.Errorf("option %s not applied correctly", tt.name)
 			}
@@ -629,11 +810,13 @@ func BenchmarkDownload(b *testing.B) {
 	}))
 	defer server.Close()
 
+	dir := b.TempDir()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		tmpFile := fmt.Sprintf("bench_download_%d.txt", i)
-		d := NewDownloader(server.URL,
+		tmpFile := filepath.Join(dir, fmt.Sprintf("bench_download_%d.txt", i))
+		d := newTestDownloader(b, server.URL,
 			WithFileName(tmpFile),
+			WithBaseDir(filepath.Join(dir, "cache")),
 			WithConcurrency(4),
 		)
 
@@ -83,7 +83,11 @@ func main() {
 		opts = append(opts, dl.WithConcurrency(concurrency))
 	}
 
-	downloader := dl.NewDownloader(url, opts...)
+	downloader, err := dl.NewDownloader(url, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 创建下载器失败: %v\n", err)
+		os.Exit(1)
+	}
 
 	// 设置下载开始回调
 	downloader.OnDownloadStart(func(total int64, filename string) {
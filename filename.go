@@ -0,0 +1,207 @@
+package dl
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFileName 无法从服务器响应和URL中得到文件名时使用的文件名
+const DefaultFileName = "download"
+
+// maxNameLen 清理后的文件名的最大字节数
+//
+// 多数文件系统限制文件名不超过255字节，这里为 .part、.dlmeta 和分片编号等后缀留出空间
+const maxNameLen = 240
+
+// reservedNames Windows保留的设备名，不区分大小写，带扩展名时同样不可用
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFileName 将服务器提供的名称转换为可以安全使用的文件名，得不到可用的文件名时返回空字符串
+//
+// 只保留最后一个路径分隔符之后的部分（绝对路径和 ".." 因此不起作用），去掉控制字符和改变文字方向的字符，
+// 替换Windows不允许的字符，去掉首尾的空格和点，避开Windows的保留设备名，并把过长的名称截断到 maxNameLen 字节。
+// 结果总是合法的UTF-8，且满足 filepath.IsLocal
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), unicode.Is(unicode.Bidi_Control, r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = truncateName(strings.Trim(name, " ."), maxNameLen)
+	if name == "" {
+		return ""
+	}
+
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = truncateName("_"+name, maxNameLen)
+	}
+	return name
+}
+
+// truncateName 把文件名截断到最多 max 字节，尽量保留扩展名，不会截断在多字节字符的中间
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > max/4 {
+		// 过长的"扩展名"不是真正的扩展名
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	n := max - len(ext)
+	for n > 0 && !utf8.RuneStart(stem[n]) {
+		n--
+	}
+	return strings.Trim(stem[:n], " .") + ext
+}
+
+// partName 返回用于分片目录和分片文件的名称
+func partName(filename string) string {
+	if name := sanitizeFileName(filename); name != "" {
+		return name
+	}
+	return DefaultFileName
+}
+
+// partDirOf 返回文件的分片目录，目录名经过清理，始终是 baseDir 的直接子目录
+func partDirOf(baseDir, filename string) string {
+	return filepath.Join(baseDir, partName(filename))
+}
+
+// safeJoin 将服务器提供的文件名拼接到 dir 下，结果会落在 dir 之外时返回 ErrUnsafePath
+func safeJoin(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// nameFromURL 返回URL路径的最后一段作为文件名，查询参数不参与命名；
+// 路径为空或以 "/" 结尾时返回空字符串
+func nameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return ""
+	}
+	return sanitizeFileName(path.Base(u.Path))
+}
+
+// nameFromDisposition 从 Content-Disposition 响应头中读取文件名，没有文件名时返回空字符串
+//
+// 优先使用 RFC 5987 的 filename*（支持UTF-8和ISO-8859-1编码），其次使用 filename
+func nameFromDisposition(v string) string {
+	if v == "" {
+		return ""
+	}
+	raw := dispositionParams(v)
+	if name := decodeExtValue(raw["filename*"]); name != "" {
+		return sanitizeFileName(name)
+	}
+	if _, params, err := mime.ParseMediaType(v); err == nil && params["filename"] != "" {
+		return sanitizeFileName(params["filename"])
+	}
+	// 部分服务器返回的文件名没有加引号，例如 filename=my file.zip
+	return sanitizeFileName(raw["filename"])
+}
+
+// dispositionParams 宽松地解析 Content-Disposition 的参数，键统一为小写，值去掉两端的引号
+func dispositionParams(v string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(v, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// decodeExtValue 解码 RFC 5987 格式的参数值，例如 UTF-8'zh'%E4%B8%AD.txt
+func decodeExtValue(v string) string {
+	charset, rest, ok := strings.Cut(v, "'")
+	if !ok {
+		return ""
+	}
+	_, encoded, ok := strings.Cut(rest, "'") // 跳过语言标记
+	if !ok {
+		return ""
+	}
+	value, err := url.PathUnescape(encoded)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(charset) {
+	case "utf-8", "us-ascii":
+		return value
+	case "iso-8859-1":
+		// ISO-8859-1 的每个字节与相同编号的Unicode码点对应
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		return string(runes)
+	default:
+		return ""
+	}
+}
+
+// resolveName 未指定文件名时根据服务器的响应确定目标文件名
+//
+// 依次使用 Content-Disposition 中的文件名、重定向之后的URL中的文件名和原始URL中的文件名，
+// 目标文件仍位于原来的目录中
+func (d *Downloader) resolveName(info *remoteInfo) error {
+	name := info.filename
+	if name == "" {
+		name = nameFromURL(info.finalURL)
+	}
+	if name == "" {
+		name = nameFromURL(d.url)
+	}
+	if name == "" {
+		name = DefaultFileName
+	}
+
+	target, err := safeJoin(filepath.Dir(d.targetPath()), name)
+	if err != nil {
+		return err
+	}
+	d.resultMu.Lock()
+	d.target = target
+	d.resultMu.Unlock()
+	return nil
+}
+
+// targetPath 返回调用方配置的或根据服务器响应确定的目标文件路径
+func (d *Downloader) targetPath() string {
+	d.resultMu.Lock()
+	defer d.resultMu.Unlock()
+	return d.target
+}
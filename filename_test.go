@@ -0,0 +1,222 @@
+package dl
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestSanitizeFileName 测试服务器提供的文件名的清理
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\windows\system.ini`, "system.ini"},
+		{"/abs/path/file.txt", "file.txt"},
+		{"..", ""},
+		{"dir/", ""},
+		{" .hidden ", "hidden"},
+		{"a<b>c:d\"e|f?g*h.txt", "a_b_c_d_e_f_g_h.txt"},
+		{"line\nbreak\x00.txt", "linebreak.txt"},
+		{"invoice\u202Efdp.exe", "invoicefdp.exe"},
+		{"bad\xffutf8.bin", "badutf8.bin"},
+		{"C:\\Windows\\notepad.exe", "notepad.exe"},
+		{strings.Repeat("长", 100) + ".tar.gz", strings.Repeat("长", 79) + ".gz"},
+		{strings.Repeat("a", 300), strings.Repeat("a", maxNameLen)},
+		{"CON", "_CON"},
+		{"nul.tar.gz", "_nul.tar.gz"},
+		{"console.log", "console.log"},
+		{"中文 文件.zip", "中文 文件.zip"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestNameFromDisposition 测试从 Content-Disposition 读取文件名
+func TestNameFromDisposition(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename=plain.txt`, "plain.txt"},
+		{`attachment; filename=my file.zip`, "my file.zip"},
+		{`attachment; filename="a.txt"; filename*=UTF-8''%E4%B8%AD%E6%96%87.txt`, "中文.txt"},
+		{`attachment; filename*=iso-8859-1'en'%E9t%E9.txt`, "été.txt"},
+		{`attachment; filename*=koi8-r''%C1.txt; filename="fallback.txt"`, "fallback.txt"},
+		{`attachment; filename="..\\..\\evil.exe"`, "evil.exe"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`inline`, ""},
+		{``, ""},
+	}
+
+	for _, tt := range tests {
+		if got := nameFromDisposition(tt.header); got != tt.want {
+			t.Errorf("nameFromDisposition(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+// TestNameFromURL 测试未指定文件名时根据URL确定的初始文件名
+func TestNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/file.txt", "file.txt"},
+		{"https://example.com/download?id=42", "download"},
+		{"https://example.com/files/", DefaultFileName},
+		{"https://example.com", DefaultFileName},
+		{"https://example.com/a%20b.iso", "a b.iso"},
+		{"https://example.com/%2e%2e", DefaultFileName},
+	}
+
+	for _, tt := range tests {
+		d := newTestDownloader(t, tt.url)
+		if d.options.FileName != tt.want || d.options.FilePath != tt.want {
+			t.Errorf("NewDownloader(%q) file = %q (%q), want %q", tt.url, d.options.FileName, d.options.FilePath, tt.want)
+		}
+	}
+}
+
+// TestDerivedFileName 测试根据 Content-Disposition 和重定向之后的URL确定文件名
+func TestDerivedFileName(t *testing.T) {
+	data := testData(16 * 1024)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/attachment", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''%E6%8A%A5%E5%91%8A.bin`)
+		w.Write(data)
+	})
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/releases/v1.2.tar.gz?token=x", http.StatusFound)
+	})
+	mux.HandleFunc("/releases/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		path string
+		opts []OptionFunc
+		want string
+	}{
+		{"content disposition", "/attachment", nil, "报告.bin"},
+		{"redirect", "/latest", nil, "v1.2.tar.gz"},
+		{"explicit name", "/attachment", []OptionFunc{WithFileName("given.bin")}, "given.bin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			chdir(t, dir)
+
+			opts := append([]OptionFunc{WithBaseDir(filepath.Join(dir, "cache"))}, tt.opts...)
+			d := newTestDownloader(t, server.URL+tt.path, opts...)
+			if err := d.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if got := d.Result().FilePath; got != tt.want {
+				t.Errorf("Result().FilePath = %q, want %q", got, tt.want)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, tt.want))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+		})
+	}
+}
+
+// chdir 切换工作目录，测试结束后恢复
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// checkSafeName 检查清理后的文件名满足安全要求
+func checkSafeName(t *testing.T, input, name string) {
+	t.Helper()
+	if name == "" {
+		return
+	}
+	if !utf8.ValidString(name) || len(name) > maxNameLen {
+		t.Fatalf("sanitize(%q) = %q: invalid UTF-8 or %d bytes", input, name, len(name))
+	}
+	if strings.ContainsAny(name, `/\<>:"|?*`) || strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 }) {
+		t.Fatalf("sanitize(%q) = %q contains unsafe characters", input, name)
+	}
+	if !filepath.IsLocal(name) || name != filepath.Base(name) {
+		t.Fatalf("sanitize(%q) = %q is not a plain local name", input, name)
+	}
+}
+
+// FuzzSanitizeFileName 测试任意输入清理后都是安全的文件名，且清理是幂等的
+func FuzzSanitizeFileName(f *testing.F) {
+	for _, seed := range []string{"file.txt", "../../etc/passwd", `C:\x\..\y`, "..", " . ", "CON.txt", "a\x00b", strings.Repeat("é", 200)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		name := sanitizeFileName(input)
+		checkSafeName(t, input, name)
+		if again := sanitizeFileName(name); again != name {
+			t.Fatalf("sanitize is not idempotent: %q -> %q -> %q", input, name, again)
+		}
+	})
+}
+
+// FuzzNameFromDisposition 测试任意 Content-Disposition 得到的文件名都是安全的
+func FuzzNameFromDisposition(f *testing.F) {
+	for _, seed := range []string{
+		`attachment; filename="a.txt"`,
+		`attachment; filename*=UTF-8''..%2F..%2Fetc%2Fpasswd`,
+		`attachment; filename*=iso-8859-1''%2e%2e`,
+		`attachment; filename=/abs; filename*=x`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		checkSafeName(t, header, nameFromDisposition(header))
+	})
+}
+
+// FuzzPartPaths 测试任意文件名对应的分片目录和分片文件都位于缓存目录之内
+func FuzzPartPaths(f *testing.F) {
+	for _, seed := range []string{"file.zip", "..", "/", "../../outside", `..\..\outside`, ""} {
+		f.Add(seed, 3)
+	}
+	root := filepath.Join("cache", "root")
+	f.Fuzz(func(t *testing.T, filename string, part int) {
+		d := newTestDownloader(t, "http://example.com/", WithBaseDir(root))
+		d.partDir = d.getPartDir(filename)
+		if filepath.Dir(d.partDir) != root {
+			t.Fatalf("part directory %q for %q is not inside %q", d.partDir, filename, root)
+		}
+		if p := d.getPartFilename(filename, part); filepath.Dir(p) != d.partDir {
+			t.Fatalf("part file %q for %q is not inside %q", p, filename, d.partDir)
+		}
+		if target, err := safeJoin(root, sanitizeFileName(filename)); err == nil && filepath.Dir(target) != root {
+			t.Fatalf("target %q for %q is not inside %q", target, filename, root)
+		}
+	})
+}
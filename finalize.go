@@ -0,0 +1,258 @@
+package dl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartSuffix 下载过程中临时文件的后缀，完成后重命名为目标文件名
+const PartSuffix = ".part"
+
+// ExistingFilePolicy 目标文件已存在时的处理策略
+type ExistingFilePolicy int
+
+const (
+	// ExistingOverwrite 覆盖已存在的文件（默认）
+	ExistingOverwrite ExistingFilePolicy = iota
+	// ExistingError 返回 ErrFileExists，不开始下载
+	ExistingError
+	// ExistingSkip 文件已存在时跳过下载
+	ExistingSkip
+	// ExistingSkipIfSame 大小与服务器一致（以及记录过的ETag一致）时跳过下载，否则覆盖
+	ExistingSkipIfSame
+	// ExistingAutoRename 下载到新的文件名，例如 "file (1).zip"
+	ExistingAutoRename
+)
+
+// Outcome 下载结束时对目标文件所做的处理
+type Outcome int
+
+const (
+	// OutcomeNone 下载没有完成（失败或已取消）
+	OutcomeNone Outcome = iota
+	// OutcomeDownloaded 目标文件原本不存在，已下载
+	OutcomeDownloaded
+	// OutcomeOverwritten 覆盖了已存在的文件
+	OutcomeOverwritten
+	// OutcomeRenamed 已存在同名文件，下载到了新的文件名
+	OutcomeRenamed
+	// OutcomeSkipped 按策略跳过了下载
+	OutcomeSkipped
+	// OutcomeNotModified 条件请求返回304，远程文件未变化
+	OutcomeNotModified
+)
+
+// String 返回结果的名称
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeDownloaded:
+		return "downloaded"
+	case OutcomeOverwritten:
+		return "overwritten"
+	case OutcomeRenamed:
+		return "renamed"
+	case OutcomeSkipped:
+		return "skipped"
+	case OutcomeNotModified:
+		return "not modified"
+	default:
+		return "none"
+	}
+}
+
+// Result 最近一次下载的结果
+type Result struct {
+	// Outcome 对目标文件所做的处理
+	Outcome Outcome
+	// FilePath 最终的文件路径，自动重命名时与配置的路径不同
+	FilePath string
+}
+
+// WithExistingFilePolicy 设置目标文件已存在时的处理策略
+func WithExistingFilePolicy(policy ExistingFilePolicy) OptionFunc {
+	return func(o *Options) {
+		o.ExistingFile = policy
+	}
+}
+
+// Result 返回最近一次 Start 的结果
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithExistingFilePolicy(ExistingAutoRename))
+//	if err := dl.Start(); err == nil {
+//	    r := dl.Result()
+//	    fmt.Println(r.Outcome, r.FilePath)
+//	}
+func (d *Downloader) Result() Result {
+	d.resultMu.Lock()
+	defer d.resultMu.Unlock()
+	return d.result
+}
+
+// setResult 记录下载结果
+func (d *Downloader) setResult(outcome Outcome) {
+	d.resultMu.Lock()
+	defer d.resultMu.Unlock()
+	d.result = Result{Outcome: outcome, FilePath: d.options.FilePath}
+}
+
+// prepareTarget 在探测远程文件之前按策略处理已存在的目标文件，返回是否跳过下载
+//
+// 每次开始下载都从调用方配置的路径重新判断，因此自动重命名的下载中断后仍能续传
+func (d *Downloader) prepareTarget() (skip bool, err error) {
+	d.setTarget(d.target)
+	d.setResult(OutcomeNone)
+	d.outcome = OutcomeDownloaded
+
+	if _, err := os.Lstat(d.target); err != nil {
+		return false, nil
+	}
+	switch d.options.ExistingFile {
+	case ExistingError:
+		return false, fmt.Errorf("%w: %s", ErrFileExists, d.target)
+	case ExistingSkip:
+		d.setResult(OutcomeSkipped)
+		return true, nil
+	case ExistingAutoRename:
+		d.setTarget(availablePath(d.target))
+		d.outcome = OutcomeRenamed
+	default:
+		d.outcome = OutcomeOverwritten
+	}
+	return false, nil
+}
+
+// sameAsRemote 判断已存在的目标文件是否与远程文件相同（ExistingSkipIfSame）
+func (d *Downloader) sameAsRemote(info *remoteInfo) bool {
+	if d.options.ExistingFile != ExistingSkipIfSame || info.size < 0 {
+		return false
+	}
+	fi, err := os.Stat(d.options.FilePath)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() != info.size {
+		return false
+	}
+	if meta := d.loadMeta(); meta != nil && meta.ETag != "" && info.etag != "" {
+		return meta.ETag == info.etag
+	}
+	return true
+}
+
+// setTarget 设置本次下载实际写入的文件路径
+func (d *Downloader) setTarget(path string) {
+	d.options.FilePath = path
+	d.options.FileName = filepath.Base(path)
+}
+
+// compoundExts 作为一个整体的复合扩展名，自动重命名时编号插在它们之前
+var compoundExts = []string{".tar.gz", ".tar.bz2", ".tar.xz"}
+
+// availablePath 返回第一个不存在的 "name (n).ext" 形式的路径
+//
+// archive.tar.gz 这类复合扩展名视为一个扩展名，重命名为 archive (1).tar.gz
+func availablePath(path string) string {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	for _, c := range compoundExts {
+		if len(base) > len(c) && strings.EqualFold(base[len(base)-len(c):], c) {
+			ext = base[len(base)-len(c):]
+			break
+		}
+	}
+	stem := base[:len(base)-len(ext)]
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// tempPath 返回下载过程中写入的临时文件路径，与目标文件位于同一目录以便原子重命名
+func (d *Downloader) tempPath() string {
+	return d.options.FilePath + PartSuffix
+}
+
+// finishedOnDisk 判断目标文件是否已经由本次下载生成，用于恢复在记录完成状态之前中断的任务
+//
+// 临时文件存在时下载还没有完成。附属文件记录的地址和大小与目标文件一致时认为已经完成；
+// ExistingError、ExistingSkip 和 ExistingAutoRename 策略下开始下载时目标文件不存在，
+// 目标文件存在即说明已经完成。其他策略下无法区分下载之前就存在的旧文件，返回false
+func (d *Downloader) finishedOnDisk() bool {
+	fi, err := os.Stat(d.options.FilePath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if _, err := os.Lstat(d.tempPath()); err == nil {
+		return false
+	}
+	if meta := d.loadMeta(); meta != nil && meta.URL == d.url && meta.Size == fi.Size() {
+		return true
+	}
+	switch d.options.ExistingFile {
+	case ExistingError, ExistingSkip, ExistingAutoRename:
+		return true
+	}
+	return false
+}
+
+// checkExisting 重命名之前再次检查目标文件，下载期间目标文件可能已被创建
+func (d *Downloader) checkExisting() error {
+	if d.options.ExistingFile != ExistingError {
+		return nil
+	}
+	if _, err := os.Lstat(d.options.FilePath); err == nil {
+		return fmt.Errorf("%w: %s", ErrFileExists, d.options.FilePath)
+	}
+	return nil
+}
+
+// finalize 将已同步到磁盘的临时文件原子地重命名为目标文件
+//
+// 重命名后同步所在目录，保证崩溃后目标文件要么不存在，要么是完整的
+func (d *Downloader) finalize(tmp string) error {
+	filename := d.options.FilePath
+
+	if err := d.checkExisting(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", tmp, err)
+	}
+	syncDir(filepath.Dir(filename))
+
+	if (d.options.RemoteTime || d.options.Conditional) && d.remote != nil {
+		if err := setModTime(filename, d.remote.lastModified); err != nil {
+			return err
+		}
+	}
+	if err := d.updateMeta(); err != nil {
+		return err
+	}
+	d.setResult(d.outcome)
+	return nil
+}
+
+// syncClose 将文件内容同步到磁盘后关闭文件
+func syncClose(f *os.File) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	return nil
+}
+
+// syncDir 同步目录项，部分平台（如Windows）不支持同步目录，忽略错误
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = f.Sync()
+	_ = f.Close()
+}
@@ -0,0 +1,161 @@
+package dl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestAtomicFinalize 测试下载失败时目标路径上不会留下不完整的文件
+func TestAtomicFinalize(t *testing.T) {
+	const size = 32 * 1024
+	data := testData(size)
+
+	mirror := newMirrorServer(data, "", -1, 0)
+	defer mirror.Close()
+	single := createTestServer(size, false)
+	defer single.Close()
+
+	for name, url := range map[string]string{"segmented": mirror.URL, "single": single.URL} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "atomic.bin")
+			d := newTestDownloader(t, url,
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithChecksum("sha-256", "0000"),
+			)
+			if err := d.Start(); !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("Start() error = %v, want %v", err, ErrChecksumMismatch)
+			}
+			for _, path := range []string{target, target + PartSuffix} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s exists after failed verification, err = %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+// TestExistingFilePolicy 测试目标文件已存在时的处理策略和下载结果
+func TestExistingFilePolicy(t *testing.T) {
+	const size = 16 * 1024
+	data := testData(size)
+	server := newMirrorServer(data, `"v1"`, -1, 0)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		policy      ExistingFilePolicy
+		existing    []byte
+		meta        string // 附属文件中记录的ETag
+		wantErr     error
+		wantOutcome Outcome
+		wantPath    string
+		wantSize    int64
+	}{
+		{"missing", ExistingError, nil, "", nil, OutcomeDownloaded, "existing.bin", size},
+		{"overwrite", ExistingOverwrite, []byte("old"), "", nil, OutcomeOverwritten, "existing.bin", size},
+		{"error", ExistingError, []byte("old"), "", ErrFileExists, OutcomeNone, "existing.bin", 3},
+		{"skip", ExistingSkip, []byte("old"), "", nil, OutcomeSkipped, "existing.bin", 3},
+		{"skip if same size", ExistingSkipIfSame, make([]byte, size), "", nil, OutcomeSkipped, "existing.bin", size},
+		{"size differs", ExistingSkipIfSame, []byte("old"), "", nil, OutcomeOverwritten, "existing.bin", size},
+		{"etag differs", ExistingSkipIfSame, make([]byte, size), `"v0"`, nil, OutcomeOverwritten, "existing.bin", size},
+		{"auto rename", ExistingAutoRename, []byte("old"), "", nil, OutcomeRenamed, "existing (1).bin", size},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "existing.bin")
+			if tt.existing != nil {
+				os.WriteFile(target, tt.existing, FilePerm)
+			}
+			if tt.meta != "" {
+				os.WriteFile(target+MetaSuffix, []byte(`{"etag":`+strconv.Quote(tt.meta)+`}`), FilePerm)
+			}
+			server.served.Store(0)
+
+			d := newTestDownloader(t, server.URL,
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithExistingFilePolicy(tt.policy),
+			)
+			if err := d.Start(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+
+			r := d.Result()
+			if r.Outcome != tt.wantOutcome {
+				t.Errorf("Result().Outcome = %v, want %v", r.Outcome, tt.wantOutcome)
+			}
+			wantPath := filepath.Join(dir, tt.wantPath)
+			if r.FilePath != wantPath {
+				t.Errorf("Result().FilePath = %s, want %s", r.FilePath, wantPath)
+			}
+			info, err := os.Stat(wantPath)
+			if err != nil {
+				t.Fatalf("target file is missing: %v", err)
+			}
+			if info.Size() != tt.wantSize {
+				t.Errorf("file size = %d, want %d", info.Size(), tt.wantSize)
+			}
+			if tt.wantSize != size && server.served.Load() != 0 {
+				t.Errorf("served %d bytes although the download was not needed", server.served.Load())
+			}
+		})
+	}
+}
+
+// TestSkipIfSameRecordsETag 测试按ETag跳过时下载完成后写入附属文件
+func TestSkipIfSameRecordsETag(t *testing.T) {
+	data := testData(8 * 1024)
+	server := newMirrorServer(data, `"v1"`, -1, 0)
+	defer server.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "synced.bin")
+	for i, want := range []Outcome{OutcomeDownloaded, OutcomeSkipped} {
+		d := newTestDownloader(t, server.URL,
+			WithFileName(target),
+			WithBaseDir(filepath.Join(dir, "cache")),
+			WithExistingFilePolicy(ExistingSkipIfSame),
+		)
+		if err := d.Start(); err != nil {
+			t.Fatalf("run %d: Start() error = %v", i, err)
+		}
+		if got := d.Result().Outcome; got != want {
+			t.Errorf("run %d: outcome = %v, want %v", i, got, want)
+		}
+	}
+
+	meta, err := os.ReadFile(target + MetaSuffix)
+	if err != nil || !strings.Contains(string(meta), `\"v1\"`) {
+		t.Errorf("sidecar = %s, err = %v", meta, err)
+	}
+}
+
+// TestAvailablePath 测试自动重命名选择的文件名
+func TestAvailablePath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"file.zip", "file (1).zip", "noext", "archive.tar.gz"} {
+		os.WriteFile(filepath.Join(dir, name), nil, FilePerm)
+	}
+
+	tests := []struct{ path, want string }{
+		{"file.zip", "file (2).zip"},
+		{"noext", "noext (1)"},
+		{"other.txt", "other (1).txt"},
+		{"archive.tar.gz", "archive (1).tar.gz"},
+		{"backup.TAR.XZ", "backup (1).TAR.XZ"},
+		{"notes.tar", "notes (1).tar"},
+	}
+	for _, tt := range tests {
+		if got := availablePath(filepath.Join(dir, tt.path)); got != filepath.Join(dir, tt.want) {
+			t.Errorf("availablePath(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package dl
+
+// volumeSpace 当前平台不支持查询可用空间，跳过磁盘空间检查
+func volumeSpace(path string) (volume string, free uint64, err error) {
+	return "", 0, errSpaceUnsupported
+}
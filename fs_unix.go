@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+
+package dl
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// volumeSpace 返回路径所在文件系统的标识和可用空间（字节）
+func volumeSpace(path string) (volume string, free uint64, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", 0, errSpaceUnsupported
+	}
+
+	var fs syscall.Statfs_t
+	if err = syscall.Statfs(path, &fs); err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("dev:%d", uint64(st.Dev)), uint64(fs.Bavail) * uint64(fs.Bsize), nil
+}
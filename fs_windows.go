@@ -0,0 +1,31 @@
+//go:build windows
+
+package dl
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// volumeSpace 返回路径所在卷的标识和可用空间（字节）
+func volumeSpace(path string) (volume string, free uint64, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := syscall.UTF16PtrFromString(abs)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var available uint64
+	r, _, e := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if r == 0 {
+		return "", 0, e
+	}
+	return strings.ToUpper(filepath.VolumeName(abs)), available, nil
+}
@@ -1,3 +1,16 @@
 module github.com/wsshow/dl
 
 go 1.22.0
+
+require (
+	github.com/dop251/goja v0.0.0-20260311135729-065cd970411c
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
+
+require (
+	github.com/dlclark/regexp2 v1.11.4 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,249 @@
+package dl
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// minRateBurst 限速时单次读取的最小字节数
+const minRateBurst = 1024
+
+// HostGovernor 按主机限制连接数和下载速率的调度器
+//
+// 同一进程中的多个下载器可以共享同一个 HostGovernor，
+// 对同一主机的请求数超过上限时，分片会排队等待空闲连接而不是失败
+type HostGovernor struct {
+	mu       sync.Mutex
+	maxConns int                   // 默认每个主机的最大连接数
+	rate     int64                 // 默认每个主机的速率上限（字节/秒）
+	limits   map[string]hostLimit  // 单独设置的主机限制
+	hosts    map[string]*hostState // 各主机的运行状态
+}
+
+// hostLimit 单个主机的限制
+type hostLimit struct {
+	maxConns int
+	rate     int64
+}
+
+// hostState 单个主机的连接和令牌桶状态
+type hostState struct {
+	active  int             // 正在使用的连接数
+	waiters []chan struct{} // 等待连接的请求（先进先出）
+	tokens  float64         // 令牌桶中剩余的字节数
+	last    time.Time       // 上次补充令牌的时间
+}
+
+// GovernorOptionFunc HostGovernor 配置函数
+type GovernorOptionFunc func(*HostGovernor)
+
+// WithMaxConnsPerHost 设置每个主机的最大连接数，0表示不限制
+func WithMaxConnsPerHost(n int) GovernorOptionFunc {
+	return func(g *HostGovernor) {
+		g.maxConns = n
+	}
+}
+
+// WithHostRate 设置每个主机的下载速率上限（字节/秒），0表示不限制
+// 速率由访问该主机的所有下载器共同分享
+func WithHostRate(bytesPerSecond int64) GovernorOptionFunc {
+	return func(g *HostGovernor) {
+		g.rate = bytesPerSecond
+	}
+}
+
+// NewHostGovernor 创建一个按主机限制连接数和速率的调度器
+//
+// 参数:
+//
+//	opts - 可选的配置函数
+//
+// 返回:
+//
+//	*HostGovernor - 调度器实例
+//
+// 示例:
+//
+//	g := NewHostGovernor(WithMaxConnsPerHost(4), WithHostRate(10<<20))
+//	d1, _ := NewDownloader(url1, WithHostGovernor(g))
+//	d2, _ := NewDownloader(url2, WithHostGovernor(g))
+func NewHostGovernor(opts ...GovernorOptionFunc) *HostGovernor {
+	g := &HostGovernor{
+		limits: make(map[string]hostLimit),
+		hosts:  make(map[string]*hostState),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// SetHostLimit 为指定主机单独设置连接数和速率上限，覆盖默认值
+//
+// 参数:
+//
+//	host - 主机名，带端口时需与URL中的写法一致，例如 "mirror.example.com" 或 "127.0.0.1:8080"
+//	maxConns - 最大连接数，0表示不限制
+//	bytesPerSecond - 速率上限（字节/秒），0表示不限制
+func (g *HostGovernor) SetHostLimit(host string, maxConns int, bytesPerSecond int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.limits[host] = hostLimit{maxConns: maxConns, rate: bytesPerSecond}
+	g.wake(host, g.state(host))
+}
+
+// limit 返回主机的限制（需持有锁）
+func (g *HostGovernor) limit(host string) hostLimit {
+	if l, ok := g.limits[host]; ok {
+		return l
+	}
+	return hostLimit{maxConns: g.maxConns, rate: g.rate}
+}
+
+// state 返回主机的运行状态，不存在时创建（需持有锁）
+func (g *HostGovernor) state(host string) *hostState {
+	h, ok := g.hosts[host]
+	if !ok {
+		h = &hostState{}
+		g.hosts[host] = h
+	}
+	return h
+}
+
+// wake 在连接数未达上限时唤醒等待者（需持有锁）
+func (g *HostGovernor) wake(host string, h *hostState) {
+	maxConns := g.limit(host).maxConns
+	for len(h.waiters) > 0 && (maxConns <= 0 || h.active < maxConns) {
+		h.active++
+		close(h.waiters[0])
+		h.waiters = h.waiters[1:]
+	}
+}
+
+// acquire 获取主机的一个连接，连接数已满时排队等待
+func (g *HostGovernor) acquire(ctx context.Context, host string) (release func(), err error) {
+	g.mu.Lock()
+	h := g.state(host)
+	ready := make(chan struct{})
+	h.waiters = append(h.waiters, ready)
+	g.wake(host, h)
+	g.mu.Unlock()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			h.active--
+			g.wake(host, h)
+		})
+	}
+
+	select {
+	case <-ready:
+		return release, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		for i, w := range h.waiters {
+			if w == ready {
+				h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+				g.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		g.mu.Unlock()
+		// 取消的同时已分配到连接，需要归还
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// wait 从主机的令牌桶中预留n个字节，令牌不足时等待
+func (g *HostGovernor) wait(ctx context.Context, host string, n int) error {
+	g.mu.Lock()
+	rate := g.limit(host).rate
+	if rate <= 0 {
+		g.mu.Unlock()
+		return nil
+	}
+
+	h := g.state(host)
+	now := time.Now()
+	burst := float64(rateBurst(rate))
+	if h.last.IsZero() {
+		h.tokens = burst
+	} else {
+		h.tokens += now.Sub(h.last).Seconds() * float64(rate)
+		if h.tokens > burst {
+			h.tokens = burst
+		}
+	}
+	h.last = now
+	h.tokens -= float64(n)
+	delay := time.Duration(-h.tokens / float64(rate) * float64(time.Second))
+	g.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader 返回按主机速率限制读取速度的io.Reader
+func (g *HostGovernor) reader(ctx context.Context, host string, r io.Reader) io.Reader {
+	g.mu.Lock()
+	rate := g.limit(host).rate
+	g.mu.Unlock()
+	if rate <= 0 {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, g: g, host: host, r: r, burst: rateBurst(rate)}
+}
+
+// rateBurst 返回令牌桶容量（约250ms的流量）
+func rateBurst(rate int64) int {
+	return max(int(rate/4), minRateBurst)
+}
+
+// rateLimitedReader 限速读取器
+type rateLimitedReader struct {
+	ctx   context.Context
+	g     *HostGovernor
+	host  string
+	r     io.Reader
+	burst int
+}
+
+// Read 实现io.Reader接口，每次读取后按读取的字节数消耗令牌
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.burst {
+		p = p[:r.burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.g.wait(r.ctx, r.host, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// hostOf 返回URL中的主机部分（包含端口）
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,227 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHostGovernorSharedLimit 测试多个下载器共享同一主机的连接上限
+func TestHostGovernorSharedLimit(t *testing.T) {
+	const size = 32 * 1024
+	data := testData(size)
+
+	var active, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			return
+		}
+		var start, end int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.WriteHeader(http.StatusPartialContent)
+		time.Sleep(20 * time.Millisecond)
+		w.Write(data[start : end+1])
+	}))
+	defer server.Close()
+
+	g := NewHostGovernor(WithMaxConnsPerHost(2))
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := newTestDownloader(t, server.URL,
+				WithFileName(filepath.Join(dir, fmt.Sprintf("governed_%d.bin", i))),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+				WithHostGovernor(g),
+			)
+			errs <- d.Start()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak connections = %d, want <= 2", p)
+	}
+}
+
+// TestHostGovernorRedirectHost 测试重定向到其他主机时，连接数按实际请求的主机限制
+func TestHostGovernorRedirectHost(t *testing.T) {
+	const size = 64 * 1024
+	data := testData(size)
+
+	var active, peak atomic.Int32
+	edge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer edge.Close()
+	// 通过 localhost 访问边缘节点，与源站的 127.0.0.1 是不同的主机
+	edgeURL := strings.Replace(edge.URL, "127.0.0.1", "localhost", 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, edgeURL+r.URL.Path, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	g := NewHostGovernor()
+	g.SetHostLimit(hostOf(edgeURL), 1, 0)
+	dir := t.TempDir()
+	d := newTestDownloader(t, origin.URL+"/file.bin",
+		WithFileName(filepath.Join(dir, "redirected.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(4),
+		WithHostGovernor(g),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if p := peak.Load(); p > 1 {
+		t.Errorf("peak connections to the redirect target = %d, want <= 1", p)
+	}
+}
+
+// TestHostGovernorProbeStop 测试探测请求等待连接配额时可以被 Stop 中断
+func TestHostGovernorProbeStop(t *testing.T) {
+	server := createTestServer(16*1024, true)
+	defer server.Close()
+
+	g := NewHostGovernor()
+	g.SetHostLimit(hostOf(server.URL), 1, 0)
+	// 占用该主机唯一的连接，探测请求只能等待
+	release, err := g.acquire(context.Background(), hostOf(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	dir := t.TempDir()
+	d := newTestDownloader(t, server.URL,
+		WithFileName(filepath.Join(dir, "blocked.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithHostGovernor(g),
+	)
+	done := make(chan error, 1)
+	go func() { done <- d.Start() }()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := d.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Start() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop() did not interrupt the probe waiting for a connection")
+	}
+}
+
+// TestHostGovernorRate 测试按主机限速
+func TestHostGovernorRate(t *testing.T) {
+	const size = 64 * 1024
+	server := createTestServer(size, true)
+	defer server.Close()
+
+	g := NewHostGovernor(WithHostRate(128 * 1024))
+	dir := t.TempDir()
+
+	begin := time.Now()
+	d := newTestDownloader(t, server.URL,
+		WithFileName(filepath.Join(dir, "rated.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(2),
+		WithHostGovernor(g),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// 64KB以128KB/s下载，扣除初始的32KB突发流量后至少需要约250ms
+	if elapsed := time.Since(begin); elapsed < 200*time.Millisecond {
+		t.Errorf("download took %v, rate limit not applied", elapsed)
+	}
+}
+
+// TestHostGovernorWait 测试连接已满时的排队、单独设置主机限制和取消等待
+func TestHostGovernorWait(t *testing.T) {
+	g := NewHostGovernor(WithMaxConnsPerHost(1))
+	ctx := context.Background()
+
+	release, err := g.acquire(ctx, "a.example.com")
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	// 其他主机不受影响
+	other, err := g.acquire(ctx, "b.example.com")
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	other()
+
+	// 同一主机需要等待，取消后返回错误
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err = g.acquire(cctx, "a.example.com"); err != context.DeadlineExceeded {
+		t.Errorf("acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 放宽该主机的限制后，等待者应被唤醒
+	got := make(chan struct{})
+	go func() {
+		r, err := g.acquire(ctx, "a.example.com")
+		if err == nil {
+			r()
+		}
+		close(got)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	g.SetHostLimit("a.example.com", 2, 0)
+
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken after raising host limit")
+	}
+	release()
+}
@@ -0,0 +1,590 @@
+package dl
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// DefaultMaxActiveJobs 任务管理器默认同时运行的任务数
+const DefaultMaxActiveJobs = 3
+
+// Priority 下载任务优先级
+//
+// 优先级越高的任务越先启动，在共享连接时按权重分得更多连接，
+// 并且可以抢占正在运行的低优先级任务
+type Priority int
+
+const (
+	// PriorityLow 低优先级，适合后台批量下载
+	PriorityLow Priority = iota
+	// PriorityNormal 普通优先级
+	PriorityNormal
+	// PriorityHigh 高优先级
+	PriorityHigh
+	// PriorityUrgent 紧急优先级
+	PriorityUrgent
+)
+
+// weight 返回优先级对应的连接权重（低:1 普通:2 高:4 紧急:8）
+func (p Priority) weight() int {
+	if p < PriorityLow {
+		return 1
+	}
+	return 1 << uint(p)
+}
+
+// JobState 下载任务状态
+type JobState int
+
+const (
+	// JobQueued 等待调度（包括被抢占后等待恢复）
+	JobQueued JobState = iota
+	// JobRunning 正在下载
+	JobRunning
+	// JobCompleted 下载完成
+	JobCompleted
+	// JobFailed 下载失败
+	JobFailed
+	// JobCanceled 任务被取消
+	JobCanceled
+)
+
+// String 返回任务状态的字符串表示
+func (s JobState) String() string {
+	switch s {
+	case JobQueued:
+		return "queued"
+	case JobRunning:
+		return "running"
+	case JobCompleted:
+		return "completed"
+	case JobFailed:
+		return "failed"
+	case JobCanceled:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+// ManagerOptions 任务管理器配置选项
+type ManagerOptions struct {
+	// MaxActive 同时运行的最大任务数，超出的任务排队等待
+	MaxActive int
+	// MaxConnections 所有任务共享的最大连接数，0表示不限制
+	MaxConnections int
+	// RestoreOptions 从队列文件恢复任务时附加的下载器配置
+	RestoreOptions []OptionFunc
+	// OnRestore 任务从队列文件恢复后、重新调度前的回调
+	OnRestore func(j *Job)
+}
+
+// ManagerOptionFunc 任务管理器配置函数
+type ManagerOptionFunc func(*ManagerOptions)
+
+// WithMaxActive 设置同时运行的最大任务数
+func WithMaxActive(n int) ManagerOptionFunc {
+	return func(o *ManagerOptions) {
+		o.MaxActive = n
+	}
+}
+
+// WithMaxConnections 设置所有任务共享的最大连接数
+// 连接按任务优先级加权公平分配，0表示不限制
+func WithMaxConnections(n int) ManagerOptionFunc {
+	return func(o *ManagerOptions) {
+		o.MaxConnections = n
+	}
+}
+
+// connScheduler 连接调度器，分片在发起请求前需要先获取连接配额
+type connScheduler interface {
+	acquire(ctx context.Context, revocable bool) (*connLease, error)
+}
+
+// connLease 已获取的连接配额
+type connLease struct {
+	revoked     chan struct{} // 配额被收回时关闭，nil表示不会被收回
+	revoking    bool          // 是否正在被收回
+	revocable   bool          // 是否允许被收回
+	job         *Job          // 所属任务
+	releaseHost func()        // 归还主机调度器中的连接
+	once        sync.Once
+}
+
+// release 归还连接配额
+func (l *connLease) release() {
+	l.once.Do(func() {
+		if l.releaseHost != nil {
+			l.releaseHost()
+		}
+		if l.job == nil {
+			return
+		}
+		m := l.job.m
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		l.job.releaseLease(l)
+		m.dispatch()
+	})
+}
+
+// isRevoked 判断配额是否已被调度器收回
+func (l *connLease) isRevoked() bool {
+	if l.revoked == nil {
+		return false
+	}
+	select {
+	case <-l.revoked:
+		return true
+	default:
+		return false
+	}
+}
+
+// connWaiter 等待连接配额的分片
+type connWaiter struct {
+	job       *Job
+	revocable bool
+	ready     chan *connLease
+}
+
+// Job 任务管理器中的下载任务
+type Job struct {
+	// ID 任务编号，按加入顺序递增
+	ID int
+
+	m          *Manager
+	d          *Downloader
+	priority   Priority
+	state      JobState
+	err        error
+	seq        int                     // 入队序号，同优先级按先后顺序调度
+	leases     map[*connLease]struct{} // 持有的连接配额
+	revoking   int                     // 正在被收回的配额数
+	preempting bool                    // 是否正在被抢占
+	canceling  bool                    // 是否正在被取消
+	preempted  int                     // 被抢占的次数
+	done       chan struct{}           // 任务结束时关闭
+}
+
+// Manager 下载任务管理器
+//
+// 管理多个下载任务的排队与并发：高优先级任务优先启动，
+// 运行中的任务按优先级加权共享连接数，运行槽位不足时会
+// 暂停（而不是丢弃）低优先级任务，待空闲后从断点继续
+type Manager struct {
+	mu      sync.Mutex
+	options *ManagerOptions
+	jobs    []*Job
+	seq     int
+	used    int           // 已分配的连接数
+	waiters []*connWaiter // 等待连接配额的分片
+
+	queuePath string // 队列文件路径，为空表示不持久化
+	saveErr   error  // 最近一次写入队列文件的错误
+}
+
+// NewManager 创建一个新的下载任务管理器
+//
+// 参数:
+//
+//	opts - 可选的配置函数
+//
+// 返回:
+//
+//	*Manager - 任务管理器实例
+//
+// 示例:
+//
+//	m := NewManager(WithMaxActive(2), WithMaxConnections(16))
+//	d, err := NewDownloader(url)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	job := m.Add(d, PriorityUrgent)
+//	err = job.Wait()
+func NewManager(opts ...ManagerOptionFunc) *Manager {
+	options := &ManagerOptions{
+		MaxActive: DefaultMaxActiveJobs,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.MaxActive <= 0 {
+		options.MaxActive = DefaultMaxActiveJobs
+	}
+
+	return &Manager{
+		options: options,
+	}
+}
+
+// Add 将下载器加入任务队列，并按优先级调度
+//
+// 加入后下载器由任务管理器控制启停，调用方不应再直接调用其Start/Stop方法。
+// 下载器应启用断点续传，否则被抢占的任务恢复时会从头下载。
+//
+// 参数:
+//
+//	d - 下载器实例
+//	priority - 任务优先级
+//
+// 返回:
+//
+//	*Job - 任务实例
+func (m *Manager) Add(d *Downloader, priority Priority) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	j := &Job{
+		ID:       m.seq,
+		m:        m,
+		d:        d,
+		priority: priority,
+		state:    JobQueued,
+		seq:      m.seq,
+		leases:   make(map[*connLease]struct{}),
+		done:     make(chan struct{}),
+	}
+	d.scheduler = j
+	m.jobs = append(m.jobs, j)
+	m.schedule()
+	return j
+}
+
+// Jobs 返回所有任务（按加入顺序）
+func (m *Manager) Jobs() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]*Job, len(m.jobs))
+	copy(jobs, m.jobs)
+	return jobs
+}
+
+// Wait 等待当前所有任务结束
+func (m *Manager) Wait() {
+	for _, j := range m.Jobs() {
+		<-j.done
+	}
+}
+
+// Err 返回最近一次写入队列文件时的错误
+func (m *Manager) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveErr
+}
+
+// schedule 按优先级启动排队中的任务，必要时抢占低优先级任务（需持有锁）
+//
+// 每次调度后都会把任务状态写入队列文件
+func (m *Manager) schedule() {
+	defer m.persist()
+
+	var queued, running []*Job
+	preempting := 0
+	for _, j := range m.jobs {
+		switch j.state {
+		case JobQueued:
+			queued = append(queued, j)
+		case JobRunning:
+			running = append(running, j)
+			if j.preempting {
+				preempting++
+			}
+		}
+	}
+
+	sort.SliceStable(queued, func(a, b int) bool {
+		if queued[a].priority != queued[b].priority {
+			return queued[a].priority > queued[b].priority
+		}
+		return queued[a].seq < queued[b].seq
+	})
+
+	free := m.options.MaxActive - len(running)
+	for i, j := range queued {
+		switch {
+		case i < free:
+			m.start(j)
+		case i < free+preempting:
+			// 已有任务正在让出槽位，无需再次抢占
+		default:
+			victim := m.preemptVictim(running, j.priority)
+			if victim == nil {
+				return
+			}
+			victim.preempting = true
+			preempting++
+			_ = victim.d.suspend()
+		}
+	}
+}
+
+// preemptVictim 在运行中的任务里选出优先级最低且低于priority的任务
+func (m *Manager) preemptVictim(running []*Job, priority Priority) *Job {
+	var victim *Job
+	for _, j := range running {
+		if j.preempting || j.canceling || j.priority >= priority {
+			continue
+		}
+		if victim == nil || j.priority < victim.priority ||
+			(j.priority == victim.priority && j.seq > victim.seq) {
+			victim = j
+		}
+	}
+	return victim
+}
+
+// start 启动任务（需持有锁）
+func (m *Manager) start(j *Job) {
+	j.state = JobRunning
+	// 被抢占的任务在这里重新初始化，保证后续的Pause只会作用于本次运行
+	if j.d.isStopped() {
+		j.d.init()
+	}
+	j.d.resetProgress()
+	go m.run(j)
+}
+
+// run 执行下载并根据结果更新任务状态
+func (m *Manager) run(j *Job) {
+	err := j.d.download()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 抢占或停止可能在下载已经完成之后才发生，此时按完成处理
+	completed := err == nil && j.d.Result().Outcome != OutcomeNone
+	preempting := j.preempting
+	j.preempting = false
+	switch {
+	case j.canceling:
+		j.finish(JobCanceled, nil)
+	case preempting && !completed:
+		// 被抢占的任务重新排队，恢复时从断点继续
+		j.preempted++
+		j.state = JobQueued
+	case err != nil:
+		j.finish(JobFailed, err)
+	case j.d.isStopped() && !completed:
+		j.finish(JobCanceled, nil)
+	default:
+		j.finish(JobCompleted, nil)
+	}
+	m.schedule()
+}
+
+// dispatch 按权重把空闲连接分配给等待中的分片，连接已满时收回超额任务的连接（需持有锁）
+func (m *Manager) dispatch() {
+	for len(m.waiters) > 0 && m.used < m.options.MaxConnections {
+		i := m.neediestWaiter(m.waiters)
+		w := m.waiters[i]
+		m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+		w.ready <- w.job.grantLease(w.revocable)
+		m.used++
+	}
+
+	// 每个正在收回的配额都会在归还后分配给等待者，只为剩余的等待者收回连接
+	revoking := 0
+	for _, j := range m.jobs {
+		revoking += j.revoking
+	}
+	waiters := make([]*connWaiter, len(m.waiters))
+	copy(waiters, m.waiters)
+	for len(waiters) > revoking {
+		i := m.neediestWaiter(waiters)
+		w := waiters[i]
+		waiters = append(waiters[:i], waiters[i+1:]...)
+
+		lease := m.surplusLease(w.job)
+		if lease == nil {
+			continue
+		}
+		lease.revoking = true
+		lease.job.revoking++
+		close(lease.revoked)
+	}
+}
+
+// neediestWaiter 返回按权重计算持有连接最少的等待者下标
+func (m *Manager) neediestWaiter(waiters []*connWaiter) int {
+	best := 0
+	for i, w := range waiters[1:] {
+		if w.job.share(0) < waiters[best].job.share(0) ||
+			(w.job.share(0) == waiters[best].job.share(0) && w.job.priority > waiters[best].job.priority) {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+// surplusLease 找出一个可以收回并转交给job的连接配额
+//
+// 只有当持有者让出一个连接后，按权重计算的份额仍不低于job多得一个连接后的份额时才收回
+func (m *Manager) surplusLease(job *Job) *connLease {
+	var victim *Job
+	for _, j := range m.jobs {
+		if j == job || j.revocableLease() == nil {
+			continue
+		}
+		if victim == nil || j.share(0) > victim.share(0) {
+			victim = j
+		}
+	}
+	if victim == nil || victim.share(-1) < job.share(1) {
+		return nil
+	}
+	return victim.revocableLease()
+}
+
+// share 返回任务持有的连接数（加上delta）与权重之比
+func (j *Job) share(delta int) float64 {
+	return float64(len(j.leases)-j.revoking+delta) / float64(j.priority.weight())
+}
+
+// revocableLease 返回任务持有的一个可收回的连接配额
+func (j *Job) revocableLease() *connLease {
+	for l := range j.leases {
+		if l.revocable && !l.revoking {
+			return l
+		}
+	}
+	return nil
+}
+
+// grantLease 为任务分配一个连接配额（需持有锁）
+func (j *Job) grantLease(revocable bool) *connLease {
+	l := &connLease{
+		revoked:   make(chan struct{}),
+		revocable: revocable,
+		job:       j,
+	}
+	j.leases[l] = struct{}{}
+	return l
+}
+
+// releaseLease 归还连接配额（需持有锁）
+func (j *Job) releaseLease(l *connLease) {
+	if _, ok := j.leases[l]; !ok {
+		return
+	}
+	delete(j.leases, l)
+	if l.revoking {
+		j.revoking--
+	}
+	j.m.used--
+}
+
+// acquire 实现connScheduler接口，按权重公平地获取连接配额
+func (j *Job) acquire(ctx context.Context, revocable bool) (*connLease, error) {
+	m := j.m
+	m.mu.Lock()
+	if m.options.MaxConnections <= 0 {
+		m.mu.Unlock()
+		return &connLease{}, nil
+	}
+
+	w := &connWaiter{job: j, revocable: revocable, ready: make(chan *connLease, 1)}
+	m.waiters = append(m.waiters, w)
+	m.dispatch()
+	m.mu.Unlock()
+
+	select {
+	case l := <-w.ready:
+		return l, nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		removed := false
+		for i, o := range m.waiters {
+			if o == w {
+				m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+				removed = true
+				break
+			}
+		}
+		m.mu.Unlock()
+		// 取消的同时已分配到配额，需要归还
+		if !removed {
+			(<-w.ready).release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// finish 结束任务（需持有锁）
+func (j *Job) finish(state JobState, err error) {
+	j.state = state
+	j.err = err
+	close(j.done)
+}
+
+// Downloader 返回任务对应的下载器，可用于设置回调
+func (j *Job) Downloader() *Downloader {
+	return j.d
+}
+
+// State 返回任务当前状态
+func (j *Job) State() JobState {
+	j.m.mu.Lock()
+	defer j.m.mu.Unlock()
+	return j.state
+}
+
+// Priority 返回任务优先级
+func (j *Job) Priority() Priority {
+	j.m.mu.Lock()
+	defer j.m.mu.Unlock()
+	return j.priority
+}
+
+// Preempted 返回任务被抢占的次数
+func (j *Job) Preempted() int {
+	j.m.mu.Lock()
+	defer j.m.mu.Unlock()
+	return j.preempted
+}
+
+// SetPriority 调整任务优先级，并立即重新调度
+func (j *Job) SetPriority(priority Priority) {
+	m := j.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j.priority = priority
+	m.schedule()
+	m.dispatch()
+}
+
+// Cancel 取消任务，已下载的分片文件会保留
+func (j *Job) Cancel() {
+	m := j.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch j.state {
+	case JobQueued:
+		j.finish(JobCanceled, nil)
+		m.persist()
+	case JobRunning:
+		j.canceling = true
+		_ = j.d.Stop()
+	}
+}
+
+// Wait 等待任务结束，返回下载过程中的错误
+func (j *Job) Wait() error {
+	<-j.done
+	j.m.mu.Lock()
+	defer j.m.mu.Unlock()
+	return j.err
+}
+
+// Done 返回任务结束时关闭的通道
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
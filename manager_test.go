@@ -0,0 +1,298 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createThrottledTestServer 创建一个支持Range请求、按固定速度输出数据的测试服务器
+func createThrottledTestServer(size int64, chunk int, delay time.Duration) *httptest.Server {
+	data := testData(size)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		start, end := int64(0), size-1
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			w.WriteHeader(http.StatusOK)
+		}
+
+		body := data[start : end+1]
+		for len(body) > 0 {
+			n := min(chunk, len(body))
+			if _, err := w.Write(body[:n]); err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			body = body[n:]
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}))
+}
+
+// testData 生成测试服务器返回的数据
+func testData(size int64) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+	return data
+}
+
+// waitFor 轮询等待条件成立
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestManagerPreemption 测试高优先级任务抢占低优先级任务，且被抢占的任务能从断点继续
+func TestManagerPreemption(t *testing.T) {
+	const size = 256 * 1024
+	slow := createThrottledTestServer(size, 4*1024, 20*time.Millisecond)
+	defer slow.Close()
+	fast := createTestServer(16*1024, true)
+	defer fast.Close()
+
+	dir := t.TempDir()
+	lowFile, urgentFile := filepath.Join(dir, "low.bin"), filepath.Join(dir, "urgent.bin")
+	cacheDir := filepath.Join(dir, "cache")
+
+	m := NewManager(WithMaxActive(1))
+
+	low := m.Add(newTestDownloader(t, slow.URL,
+		WithFileName(lowFile),
+		WithBaseDir(cacheDir),
+		WithConcurrency(2),
+	), PriorityLow)
+
+	var loaded atomic.Int64
+	low.Downloader().OnProgress(func(l, total int64, rate string) {
+		loaded.Store(l)
+	})
+	var canceled atomic.Int32
+	low.Downloader().OnDownloadCanceled(func(string) {
+		canceled.Add(1)
+	})
+	waitFor(t, 2*time.Second, func() bool {
+		return low.State() == JobRunning && loaded.Load() > 0
+	})
+
+	urgent := m.Add(newTestDownloader(t, fast.URL,
+		WithFileName(urgentFile),
+		WithBaseDir(cacheDir),
+		WithConcurrency(2),
+	), PriorityUrgent)
+
+	select {
+	case <-urgent.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("urgent job did not finish in time")
+	}
+	if err := urgent.Wait(); err != nil {
+		t.Fatalf("urgent job error = %v", err)
+	}
+	if low.State() == JobCompleted {
+		t.Error("low priority job should not finish before the urgent one")
+	}
+
+	if err := low.Wait(); err != nil {
+		t.Fatalf("low job error = %v", err)
+	}
+	if low.Preempted() == 0 {
+		t.Error("low priority job was not preempted")
+	}
+	if n := canceled.Load(); n != 0 {
+		t.Errorf("OnDownloadCanceled called %d times for a preempted job", n)
+	}
+	if loaded.Load() != size {
+		t.Errorf("loaded = %v, want %v", loaded.Load(), size)
+	}
+
+	got, err := os.ReadFile(lowFile)
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(got, testData(size)) {
+		t.Error("resumed file content mismatch")
+	}
+}
+
+// TestManagerPreemptAfterFinish 测试抢占发生在下载已经完成之后时，任务按完成处理而不是重新排队
+func TestManagerPreemptAfterFinish(t *testing.T) {
+	server := createTestServer(16*1024, true)
+	defer server.Close()
+	dir := t.TempDir()
+
+	m := NewManager(WithMaxActive(1))
+	d := newTestDownloader(t, server.URL, WithFileName(filepath.Join(dir, "low.bin")), WithBaseDir(filepath.Join(dir, "cache")))
+	var urgent *Job
+	// 完成回调在下载结果确定之后、任务状态更新之前执行，此时加入的高优先级任务会抢占该任务
+	d.OnDownloadFinished(func(string) {
+		urgent = m.Add(newTestDownloader(t, server.URL, WithFileName(filepath.Join(dir, "urgent.bin")), WithBaseDir(filepath.Join(dir, "cache"))), PriorityUrgent)
+	})
+	low := m.Add(d, PriorityLow)
+
+	select {
+	case <-low.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("low job did not finish, state = %v", low.State())
+	}
+	if err := low.Wait(); err != nil || low.State() != JobCompleted {
+		t.Fatalf("low job state = %v, error = %v; want completed", low.State(), err)
+	}
+	if low.Preempted() != 0 {
+		t.Errorf("finished job was counted as preempted %d times", low.Preempted())
+	}
+	if err := urgent.Wait(); err != nil {
+		t.Fatalf("urgent job error = %v", err)
+	}
+}
+
+// TestManagerQueueOrder 测试排队任务按优先级启动
+func TestManagerQueueOrder(t *testing.T) {
+	server := createThrottledTestServer(8*1024, 1024, 10*time.Millisecond)
+	defer server.Close()
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+
+	m := NewManager(WithMaxActive(1))
+
+	var order []int
+	done := make(chan int, 3)
+	priorities := []Priority{PriorityHigh, PriorityLow, PriorityNormal}
+	for i, p := range priorities {
+		file := filepath.Join(dir, fmt.Sprintf("order_%d.bin", i))
+		d := newTestDownloader(t, server.URL, WithFileName(file), WithBaseDir(cacheDir), WithConcurrency(1))
+		d.OnDownloadFinished(func(string) { done <- i })
+		m.Add(d, p)
+	}
+	m.Wait()
+	close(done)
+	for i := range done {
+		order = append(order, i)
+	}
+
+	// 第一个任务加入后立即启动，其余任务按优先级排队
+	want := []int{0, 2, 1}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("finish order = %v, want %v", order, want)
+	}
+}
+
+// TestManagerFairShare 测试连接数按优先级加权分配，且超额连接会被收回
+func TestManagerFairShare(t *testing.T) {
+	m := NewManager(WithMaxActive(2), WithMaxConnections(3))
+	low := &Job{m: m, priority: PriorityLow, leases: make(map[*connLease]struct{}), done: make(chan struct{})}
+	high := &Job{m: m, priority: PriorityHigh, leases: make(map[*connLease]struct{}), done: make(chan struct{})}
+	m.jobs = []*Job{low, high}
+
+	ctx := context.Background()
+	var lowLeases []*connLease
+	for i := 0; i < 3; i++ {
+		l, err := low.acquire(ctx, true)
+		if err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+		lowLeases = append(lowLeases, l)
+	}
+
+	got := make(chan *connLease, 1)
+	go func() {
+		l, _ := high.acquire(ctx, true)
+		got <- l
+	}()
+
+	// 高优先级任务等待时，低优先级任务的一个连接应被收回
+	var revoked *connLease
+	waitFor(t, time.Second, func() bool {
+		for _, l := range lowLeases {
+			if l.isRevoked() {
+				revoked = l
+				return true
+			}
+		}
+		return false
+	})
+	revoked.release()
+
+	select {
+	case l := <-got:
+		if l.job != high {
+			t.Error("lease granted to wrong job")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("high priority job did not get a connection")
+	}
+
+	// 高优先级(权重4)持有1个、低优先级(权重1)持有2个时，再等待的连接应继续从低优先级收回
+	go func() {
+		l, _ := high.acquire(ctx, true)
+		got <- l
+	}()
+	waitFor(t, time.Second, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return low.revoking == 1
+	})
+}
+
+// TestManagerCancel 测试取消任务
+func TestManagerCancel(t *testing.T) {
+	server := createThrottledTestServer(64*1024, 1024, 20*time.Millisecond)
+	defer server.Close()
+
+	dir := t.TempDir()
+	file, cacheDir := filepath.Join(dir, "cancel.bin"), filepath.Join(dir, "cache")
+
+	m := NewManager(WithMaxActive(1))
+	running := m.Add(newTestDownloader(t, server.URL, WithFileName(file), WithBaseDir(cacheDir)), PriorityNormal)
+	queued := m.Add(newTestDownloader(t, server.URL, WithFileName(file), WithBaseDir(cacheDir)), PriorityNormal)
+
+	queued.Cancel()
+	if queued.State() != JobCanceled {
+		t.Errorf("queued job state = %v, want %v", queued.State(), JobCanceled)
+	}
+
+	waitFor(t, 2*time.Second, func() bool { return running.State() == JobRunning })
+	running.Cancel()
+	select {
+	case <-running.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("running job did not stop in time")
+	}
+	if running.State() != JobCanceled {
+		t.Errorf("running job state = %v, want %v", running.State(), JobCanceled)
+	}
+}
@@ -0,0 +1,251 @@
+package dl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metalinkNoPriority 未指定优先级的URL排在最后
+const metalinkNoPriority = 1 << 30
+
+// preferredHashes 按优先顺序排列的整文件校验算法
+var preferredHashes = []string{"sha-512", "sha-384", "sha-256", "sha-1", "md5"}
+
+// Metalink 解析后的Metalink文档，支持 RFC 5854（.meta4）和 Metalink 3.0（.metalink）
+type Metalink struct {
+	Files []*MetalinkFile
+}
+
+// MetalinkFile Metalink文档中描述的一个文件
+type MetalinkFile struct {
+	// Name 文件名，可能包含相对路径
+	Name string
+	// Size 文件大小，0表示未知
+	Size int64
+	// Hashes 整个文件的哈希，键为统一后的算法名称（如 sha-256）
+	Hashes map[string]string
+	// Pieces 分块哈希，没有时为nil
+	Pieces *PieceHashes
+	// URLs 按优先级从高到低排列的HTTP(S)下载地址
+	URLs []MetalinkURL
+}
+
+// MetalinkURL 文件的一个下载地址
+type MetalinkURL struct {
+	URL string
+	// Priority 优先级，数值越小越优先
+	Priority int
+	// Location ISO 3166-1 国家代码
+	Location string
+}
+
+// metalinkDoc 同时兼容 v4 和 v3 的XML结构（不区分命名空间）
+type metalinkDoc struct {
+	XMLName xml.Name       `xml:"metalink"`
+	Files   []metalinkItem `xml:"file"`       // v4
+	V3Files []metalinkItem `xml:"files>file"` // v3
+}
+
+type metalinkItem struct {
+	Name string `xml:"name,attr"`
+	Size string `xml:"size"`
+	// v4
+	Hashes []metalinkHash  `xml:"hash"`
+	Pieces *metalinkPieces `xml:"pieces"`
+	URLs   []metalinkURL   `xml:"url"`
+	// v3
+	Verification struct {
+		Hashes []metalinkHash  `xml:"hash"`
+		Pieces *metalinkPieces `xml:"pieces"`
+	} `xml:"verification"`
+	Resources struct {
+		URLs []metalinkURL `xml:"url"`
+	} `xml:"resources"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Piece string `xml:"piece,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkPieces struct {
+	Length int64          `xml:"length,attr"`
+	Type   string         `xml:"type,attr"`
+	Hashes []metalinkHash `xml:"hash"`
+}
+
+type metalinkURL struct {
+	Priority   string `xml:"priority,attr"`   // v4，1最高
+	Preference string `xml:"preference,attr"` // v3，100最高
+	Location   string `xml:"location,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// ParseMetalink 解析Metalink文档，自动识别 RFC 5854 和 Metalink 3.0 格式
+//
+// 只保留HTTP(S)下载地址，没有可用地址的文件会被忽略
+func ParseMetalink(r io.Reader) (*Metalink, error) {
+	var doc metalinkDoc
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse metalink: %w", err)
+	}
+
+	m := &Metalink{}
+	for _, item := range append(doc.Files, doc.V3Files...) {
+		f, err := item.file()
+		if err != nil {
+			return nil, err
+		}
+		if len(f.URLs) > 0 {
+			m.Files = append(m.Files, f)
+		}
+	}
+	if len(m.Files) == 0 {
+		return nil, ErrNoMetalinkFile
+	}
+	return m, nil
+}
+
+// file 将XML中的文件描述转换为 MetalinkFile
+func (item *metalinkItem) file() (*MetalinkFile, error) {
+	f := &MetalinkFile{
+		Name:   strings.TrimSpace(item.Name),
+		Hashes: make(map[string]string),
+	}
+	if size := strings.TrimSpace(item.Size); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid size %q for %s", size, f.Name)
+		}
+		f.Size = n
+	}
+
+	for _, h := range append(item.Hashes, item.Verification.Hashes...) {
+		f.Hashes[normalizeHashName(h.Type)] = strings.TrimSpace(h.Value)
+	}
+
+	pieces := item.Pieces
+	if pieces == nil {
+		pieces = item.Verification.Pieces
+	}
+	if pieces != nil && len(pieces.Hashes) > 0 {
+		if pieces.Length <= 0 {
+			return nil, fmt.Errorf("invalid piece length %d for %s", pieces.Length, f.Name)
+		}
+		// v3 通过piece属性标注顺序，v4 按文档顺序排列
+		hashes := append([]metalinkHash(nil), pieces.Hashes...)
+		sort.SliceStable(hashes, func(i, j int) bool {
+			a, _ := strconv.Atoi(hashes[i].Piece)
+			b, _ := strconv.Atoi(hashes[j].Piece)
+			return a < b
+		})
+		f.Pieces = &PieceHashes{Algorithm: normalizeHashName(pieces.Type), Length: pieces.Length}
+		for _, h := range hashes {
+			f.Pieces.Hashes = append(f.Pieces.Hashes, strings.TrimSpace(h.Value))
+		}
+	}
+
+	for _, u := range append(item.URLs, item.Resources.URLs...) {
+		raw := strings.TrimSpace(u.Value)
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			continue
+		}
+		f.URLs = append(f.URLs, MetalinkURL{URL: raw, Priority: u.priority(), Location: u.Location})
+	}
+	sort.SliceStable(f.URLs, func(i, j int) bool {
+		return f.URLs[i].Priority < f.URLs[j].Priority
+	})
+	return f, nil
+}
+
+// priority 将 v4 的priority和 v3 的preference统一为数值越小越优先
+func (u *metalinkURL) priority() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(u.Priority)); err == nil && n > 0 {
+		return n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(u.Preference)); err == nil && n >= 0 && n <= 100 {
+		return 101 - n
+	}
+	return metalinkNoPriority
+}
+
+// Checksum 返回可用的最强整文件校验和，没有时返回nil
+func (f *MetalinkFile) Checksum() *Checksum {
+	for _, algo := range preferredHashes {
+		if sum, ok := f.Hashes[algo]; ok && sum != "" {
+			return &Checksum{Algorithm: algo, Sum: sum}
+		}
+	}
+	return nil
+}
+
+// NewDownloader 根据文件描述创建多源下载器
+//
+// 下载地址按优先级排列，文件名取自 Metalink 中的名称（只保留最后一级并清理不安全的字符），
+// 下载完成后按分块哈希和整文件哈希校验；opts 中的配置会覆盖这些默认值
+func (f *MetalinkFile) NewDownloader(opts ...OptionFunc) (*Downloader, error) {
+	if len(f.URLs) == 0 {
+		return nil, ErrNoMetalinkFile
+	}
+	urls := make([]string, 0, len(f.URLs))
+	for _, u := range f.URLs {
+		urls = append(urls, u.URL)
+	}
+
+	var defaults []OptionFunc
+	if name := sanitizeFileName(f.Name); name != "" {
+		defaults = append(defaults, WithFileName(name))
+	}
+	if f.Size > 0 {
+		defaults = append(defaults, WithExpectedSize(f.Size))
+	}
+	if c := f.Checksum(); c != nil {
+		defaults = append(defaults, WithChecksum(c.Algorithm, c.Sum))
+	}
+	if f.Pieces != nil {
+		defaults = append(defaults, WithPieceHashes(f.Pieces))
+	}
+
+	return NewMultiSourceDownloader(urls, append(defaults, opts...)...)
+}
+
+// NewMetalinkDownloader 读取Metalink文件，为其中的第一个文件创建多源下载器
+//
+// 参数:
+//
+//	path - .meta4 或 .metalink 文件路径
+//	opts - 可选的配置函数
+//
+// 返回:
+//
+//	*Downloader - 配置好的下载器实例
+//	error - 读取或解析失败时返回错误
+//
+// 示例:
+//
+//	dl, err := NewMetalinkDownloader("ubuntu.iso.meta4", WithConcurrency(8))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	err = dl.Start()
+func NewMetalinkDownloader(path string, opts ...OptionFunc) (*Downloader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metalink: %w", err)
+	}
+	defer f.Close()
+
+	m, err := ParseMetalink(f)
+	if err != nil {
+		return nil, err
+	}
+	return m.Files[0].NewDownloader(opts...)
+}
@@ -0,0 +1,238 @@
+package dl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// metalinkV4 构造一个 RFC 5854 格式的Metalink文档
+func metalinkV4(name string, data []byte, pieceLen int, urls ...string) string {
+	var b strings.Builder
+	sum := sha256.Sum256(data)
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="%s">
+    <size>%d</size>
+    <hash type="sha-256">%s</hash>
+    <pieces length="%d" type="sha-1">
+`, name, len(data), hex.EncodeToString(sum[:]), pieceLen)
+	for off := 0; off < len(data); off += pieceLen {
+		p := sha1.Sum(data[off:min(off+pieceLen, len(data))])
+		fmt.Fprintf(&b, "      <hash>%s</hash>\n", hex.EncodeToString(p[:]))
+	}
+	b.WriteString("    </pieces>\n")
+	for i, u := range urls {
+		fmt.Fprintf(&b, "    <url location=\"de\" priority=\"%d\">%s</url>\n", i+1, u)
+	}
+	b.WriteString("  </file>\n</metalink>\n")
+	return b.String()
+}
+
+// TestParseMetalink 测试解析 v4 和 v3 格式的Metalink文档
+func TestParseMetalink(t *testing.T) {
+	v3 := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="dir/example.iso">
+      <size>2048</size>
+      <verification>
+        <hash type="md5">0123456789abcdef0123456789abcdef</hash>
+        <hash type="sha1">a94a8fe5ccb19ba61c4c0873d391e987982fbbd3</hash>
+        <pieces length="1024" type="sha1">
+          <hash piece="1">bbbb</hash>
+          <hash piece="0">aaaa</hash>
+        </pieces>
+      </verification>
+      <resources>
+        <url type="ftp" preference="100">ftp://ftp.example.com/example.iso</url>
+        <url type="http" location="us" preference="50">http://us.example.com/example.iso</url>
+        <url type="http" location="de" preference="90">http://de.example.com/example.iso</url>
+      </resources>
+    </file>
+  </files>
+</metalink>`
+
+	tests := []struct {
+		name      string
+		doc       string
+		fileName  string
+		size      int64
+		urls      []string
+		checksum  string
+		pieceAlgo string
+		pieces    []string
+	}{
+		{
+			name:      "v4",
+			doc:       metalinkV4("v4.bin", []byte("hello"), 2, "http://b.example.com/v4.bin", "http://a.example.com/v4.bin"),
+			fileName:  "v4.bin",
+			size:      5,
+			urls:      []string{"http://b.example.com/v4.bin", "http://a.example.com/v4.bin"},
+			checksum:  "sha-256",
+			pieceAlgo: "sha-1",
+		},
+		{
+			name:      "v3",
+			doc:       v3,
+			fileName:  "dir/example.iso",
+			size:      2048,
+			urls:      []string{"http://de.example.com/example.iso", "http://us.example.com/example.iso"},
+			checksum:  "sha-1",
+			pieceAlgo: "sha-1",
+			pieces:    []string{"aaaa", "bbbb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMetalink(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatalf("ParseMetalink() error = %v", err)
+			}
+			if len(m.Files) != 1 {
+				t.Fatalf("len(Files) = %d, want 1", len(m.Files))
+			}
+			f := m.Files[0]
+			if f.Name != tt.fileName || f.Size != tt.size {
+				t.Errorf("file = %s (%d), want %s (%d)", f.Name, f.Size, tt.fileName, tt.size)
+			}
+			var urls []string
+			for _, u := range f.URLs {
+				urls = append(urls, u.URL)
+			}
+			if fmt.Sprint(urls) != fmt.Sprint(tt.urls) {
+				t.Errorf("URLs = %v, want %v", urls, tt.urls)
+			}
+			if c := f.Checksum(); c == nil || c.Algorithm != tt.checksum {
+				t.Errorf("Checksum() = %+v, want algorithm %s", c, tt.checksum)
+			}
+			if f.Pieces == nil || f.Pieces.Algorithm != tt.pieceAlgo {
+				t.Fatalf("Pieces = %+v, want algorithm %s", f.Pieces, tt.pieceAlgo)
+			}
+			if tt.pieces != nil && fmt.Sprint(f.Pieces.Hashes) != fmt.Sprint(tt.pieces) {
+				t.Errorf("piece hashes = %v, want %v", f.Pieces.Hashes, tt.pieces)
+			}
+		})
+	}
+
+	if _, err := ParseMetalink(strings.NewReader(`<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`)); !errors.Is(err, ErrNoMetalinkFile) {
+		t.Errorf("ParseMetalink() error = %v, want %v", err, ErrNoMetalinkFile)
+	}
+}
+
+// TestMetalinkDownload 测试通过Metalink文件从多个镜像下载并校验
+func TestMetalinkDownload(t *testing.T) {
+	const size = 96 * 1024
+	data := testData(size)
+
+	a := newMirrorServer(data, "", -1, 0)
+	defer a.Close()
+	b := newMirrorServer(data, "", -1, 0)
+	defer b.Close()
+
+	dir := t.TempDir()
+	meta := filepath.Join(dir, "file.meta4")
+	doc := metalinkV4("file.bin", data, 16*1024, a.URL+"/file.bin", b.URL+"/file.bin")
+	if err := os.WriteFile(meta, []byte(doc), FilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "out", "file.bin")
+	d, err := NewMetalinkDownloader(meta,
+		WithFileName(target),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(3),
+	)
+	if err != nil {
+		t.Fatalf("NewMetalinkDownloader() error = %v", err)
+	}
+	if err = d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("downloaded file mismatch, err = %v", err)
+	}
+}
+
+// TestMetalinkVerifyFailure 测试镜像内容损坏时分块和整文件校验失败
+func TestMetalinkVerifyFailure(t *testing.T) {
+	const size = 64 * 1024
+	data := testData(size)
+	corrupt := bytes.Clone(data)
+	corrupt[20*1024] ^= 0xff
+
+	server := newMirrorServer(corrupt, "", -1, 0)
+	defer server.Close()
+
+	m, err := ParseMetalink(strings.NewReader(metalinkV4("bad.bin", data, 16*1024, server.URL)))
+	if err != nil {
+		t.Fatalf("ParseMetalink() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		drop func(f *MetalinkFile)
+		want error
+	}{
+		{"pieces", func(f *MetalinkFile) {}, ErrPieceMismatch},
+		{"checksum", func(f *MetalinkFile) { f.Pieces = nil }, ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := *m.Files[0]
+			tt.drop(&f)
+			d, err := f.NewDownloader(
+				WithFileName(filepath.Join(dir, tt.name+".bin")),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(2),
+			)
+			if err != nil {
+				t.Fatalf("NewDownloader() error = %v", err)
+			}
+			err = d.Start()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == ErrPieceMismatch && !strings.Contains(err.Error(), "[1]") {
+				t.Errorf("error %q does not name the corrupt piece", err)
+			}
+		})
+	}
+}
+
+// TestMetalinkFileName 测试Metalink中的文件名经过清理，不会写到目标目录之外
+func TestMetalinkFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"dir/example.iso", "example.iso"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\boot.ini`, "boot.ini"},
+		{"/abs/image.img", "image.img"},
+		{"..", "mirror.bin"},
+		{"", "mirror.bin"},
+	}
+
+	for _, tt := range tests {
+		f := &MetalinkFile{Name: tt.name, URLs: []MetalinkURL{{URL: "http://example.com/mirror.bin"}}}
+		d, err := f.NewDownloader()
+		if err != nil {
+			t.Fatalf("NewDownloader() error = %v", err)
+		}
+		if d.options.FilePath != tt.want {
+			t.Errorf("name %q: FilePath = %q, want %q", tt.name, d.options.FilePath, tt.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxSourceFailures 下载源连续失败多少次后停用
+const maxSourceFailures = 3
+
+// multiSourceSplit 多源下载时每个并发协程对应的分片数
+// 分片越小，速度快的下载源越能承担更多的分片
+const multiSourceSplit = 4
+
+// source 下载源
+type source struct {
+	url      string
+	resolved string // 探测时跟随重定向得到的最终地址
+	host     string
+	active   int           // 正在使用该源的连接数
+	bytes    int64         // 已从该源下载的字节数
+	elapsed  time.Duration // 从该源下载所用的时间
+	failures int           // 连续失败次数
+	disabled bool          // 是否已停用
+}
+
+// speed 返回下载源的实测速度（字节/秒），尚未测速的源返回+Inf以便优先尝试
+func (s *source) speed() float64 {
+	if s.elapsed <= 0 {
+		return math.Inf(1)
+	}
+	return float64(s.bytes) / s.elapsed.Seconds()
+}
+
+// newSources 根据URL列表创建下载源
+func newSources(urls []string) []*source {
+	sources := make([]*source, 0, len(urls))
+	for _, u := range urls {
+		sources = append(sources, &source{url: u, host: hostOf(u)})
+	}
+	return sources
+}
+
+// NewMultiSourceDownloader 创建一个从多个镜像下载同一文件的下载器
+//
+// 开始下载前会向每个镜像发送HEAD请求，确认各镜像的文件大小（以及都提供时的ETag）一致；
+// 下载时分片按各镜像的实测速度分配，某个镜像出错时，分片会切换到其他镜像从断点继续。
+// 文件名默认从第一个URL中提取。
+//
+// 参数:
+//
+//	urls - 同一文件的多个下载地址
+//	opts - 可选的配置函数
+//
+// 返回:
+//
+//	*Downloader - 配置好的下载器实例
+//	error - 与 NewDownloader 相同
+//
+// 示例:
+//
+//	dl, err := NewMultiSourceDownloader([]string{
+//	    "https://mirror1.example.com/file.iso",
+//	    "https://mirror2.example.com/file.iso",
+//	}, WithConcurrency(8))
+func NewMultiSourceDownloader(urls []string, opts ...OptionFunc) (*Downloader, error) {
+	primary := ""
+	if len(urls) > 0 {
+		primary = urls[0]
+	}
+	d, err := NewDownloader(primary, opts...)
+	if err != nil {
+		return nil, err
+	}
+	d.sources = newSources(urls)
+	return d, nil
+}
+
+// probeSources 并发探测所有下载源，校验文件信息是否一致，并停用不可用的源
+func (d *Downloader) probeSources(ctx context.Context) (*remoteInfo, error) {
+	infos := make([]*remoteInfo, len(d.sources))
+	errs := make([]error, len(d.sources))
+
+	var wg sync.WaitGroup
+	for i, s := range d.sources {
+		wg.Add(1)
+		go func(i int, s *source) {
+			defer wg.Done()
+			infos[i], errs[i] = d.probeURL(ctx, s.url)
+		}(i, s)
+	}
+	wg.Wait()
+
+	d.srcMu.Lock()
+	defer d.srcMu.Unlock()
+
+	var ref *remoteInfo
+	var refURL string
+	var firstErr error
+	for i, s := range d.sources {
+		info := infos[i]
+		if errs[i] == nil && info.status == http.StatusNotModified {
+			// 任一下载源确认文件未变化即可结束
+			return info, nil
+		}
+		if errs[i] == nil && info.status != http.StatusOK {
+			errs[i] = fmt.Errorf("unexpected status code %d from %s", info.status, s.url)
+		}
+		if errs[i] != nil {
+			s.disabled = true
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+
+		s.resolved = info.finalURL
+
+		// 校验各下载源的文件大小和ETag是否一致
+		if ref == nil {
+			ref, refURL = info, s.url
+			continue
+		}
+		if info.size != ref.size {
+			return nil, fmt.Errorf("%w: size %d from %s, %d from %s",
+				ErrSourceMismatch, ref.size, refURL, info.size, s.url)
+		}
+		if info.etag != "" && ref.etag != "" && info.etag != ref.etag {
+			return nil, fmt.Errorf("%w: ETag %s from %s, %s from %s",
+				ErrSourceMismatch, ref.etag, refURL, info.etag, s.url)
+		}
+	}
+	if ref == nil {
+		return nil, firstErr
+	}
+
+	// 只有支持Range请求的源才能参与分段下载
+	ranged := 0
+	for i, s := range d.sources {
+		if !s.disabled && infos[i].acceptRanges {
+			ranged++
+		}
+	}
+	if ranged > 0 {
+		for i, s := range d.sources {
+			if !s.disabled && !infos[i].acceptRanges {
+				s.disabled = true
+			}
+		}
+	}
+
+	merged := *ref
+	merged.acceptRanges = ranged > 0
+	return &merged, nil
+}
+
+// resetSources 重新启用所有下载源，每次探测前调用
+func (d *Downloader) resetSources() {
+	d.srcMu.Lock()
+	defer d.srcMu.Unlock()
+
+	for _, s := range d.sources {
+		s.disabled, s.failures, s.resolved = false, 0, ""
+	}
+}
+
+// disableSource 停用下载源，即使它是最后一个可用的源
+func (d *Downloader) disableSource(s *source) {
+	d.srcMu.Lock()
+	s.disabled = true
+	d.srcMu.Unlock()
+
+	d.rangeProbes.remove(s.host)
+}
+
+// primaryURL 返回第一个可用下载源的地址
+func (d *Downloader) primaryURL() string {
+	d.srcMu.Lock()
+	defer d.srcMu.Unlock()
+
+	for _, s := range d.sources {
+		if !s.disabled {
+			return s.target()
+		}
+	}
+	return d.url
+}
+
+// pickSource 选择一个下载源并占用它
+//
+// 按 实测速度/(活跃连接数+1) 选择，尚未测速的源优先；同分时选择活跃连接更少的源
+func (d *Downloader) pickSource(exclude map[*source]bool) *source {
+	d.srcMu.Lock()
+	defer d.srcMu.Unlock()
+
+	var best *source
+	var bestScore float64
+	for _, s := range d.sources {
+		if s.disabled || exclude[s] {
+			continue
+		}
+		score := s.speed() / float64(s.active+1)
+		if best == nil || score > bestScore || (score == bestScore && s.active < best.active) {
+			best, bestScore = s, score
+		}
+	}
+	if best != nil {
+		best.active++
+	}
+	return best
+}
+
+// reportSource 归还下载源并记录本次下载的字节数和耗时
+//
+// 连续失败达到上限的源会被停用，但至少保留一个可用的源
+func (d *Downloader) reportSource(s *source, written int64, elapsed time.Duration, failed bool) {
+	d.srcMu.Lock()
+	defer d.srcMu.Unlock()
+
+	s.active--
+	s.bytes += written
+	s.elapsed += elapsed
+	if !failed {
+		s.failures = 0
+		return
+	}
+
+	s.failures++
+	if s.failures < maxSourceFailures {
+		return
+	}
+	for _, o := range d.sources {
+		if o != s && !o.disabled {
+			s.disabled = true
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package dl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mirrorServer 测试用镜像服务器，记录提供的字节数
+type mirrorServer struct {
+	*httptest.Server
+	served atomic.Int64
+}
+
+// newMirrorServer 创建一个镜像服务器
+// failAfter 大于等于0时，每个Range响应在写入failAfter字节后中断连接；delay为每次写入之间的间隔
+func newMirrorServer(data []byte, etag string, failAfter int, delay time.Duration) *mirrorServer {
+	m := &mirrorServer{}
+	size := int64(len(data))
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			return
+		}
+
+		var start, end int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		body := data[start : end+1]
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+
+		if failAfter >= 0 && failAfter < len(body) {
+			n, _ := w.Write(body[:failAfter])
+			m.served.Add(int64(n))
+			// 中断连接，让客户端读到不完整的响应体
+			panic(http.ErrAbortHandler)
+		}
+		for len(body) > 0 {
+			chunk := min(len(body), 2048)
+			n, err := w.Write(body[:chunk])
+			m.served.Add(int64(n))
+			if err != nil {
+				return
+			}
+			body = body[chunk:]
+			if delay > 0 {
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+				time.Sleep(delay)
+			}
+		}
+	}))
+	return m
+}
+
+// TestMultiSourceFailover 测试镜像出错时分片切换到其他镜像继续下载
+func TestMultiSourceFailover(t *testing.T) {
+	const size = 128 * 1024
+	data := testData(size)
+
+	good := newMirrorServer(data, `"v1"`, -1, 0)
+	defer good.Close()
+	broken := newMirrorServer(data, `"v1"`, 1000, 0)
+	defer broken.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "mirrored.bin")
+	d := newTestMultiSourceDownloader(t, []string{broken.URL, good.URL},
+		WithFileName(target),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(4),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file content mismatch")
+	}
+	if broken.served.Load() == 0 {
+		t.Error("broken mirror was never used")
+	}
+}
+
+// TestMultiSourceWeighted 测试分片按镜像的实测速度分配
+func TestMultiSourceWeighted(t *testing.T) {
+	const size = 256 * 1024
+	data := testData(size)
+
+	fast := newMirrorServer(data, "", -1, 0)
+	defer fast.Close()
+	slow := newMirrorServer(data, "", -1, 5*time.Millisecond)
+	defer slow.Close()
+
+	dir := t.TempDir()
+	d := newTestMultiSourceDownloader(t, []string{slow.URL, fast.URL},
+		WithFileName(filepath.Join(dir, "weighted.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(2),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if fast.served.Load() <= slow.served.Load() {
+		t.Errorf("fast mirror served %d bytes, slow mirror served %d bytes", fast.served.Load(), slow.served.Load())
+	}
+	if total := fast.served.Load() + slow.served.Load(); total != size {
+		t.Errorf("served %d bytes in total, want %d", total, size)
+	}
+}
+
+// TestMultiSourceMismatch 测试镜像之间文件大小或ETag不一致时拒绝下载
+func TestMultiSourceMismatch(t *testing.T) {
+	data := testData(16 * 1024)
+
+	a := newMirrorServer(data, `"v1"`, -1, 0)
+	defer a.Close()
+	b := newMirrorServer(data[:8*1024], `"v1"`, -1, 0)
+	defer b.Close()
+	c := newMirrorServer(data, `"v2"`, -1, 0)
+	defer c.Close()
+
+	dir := t.TempDir()
+	for _, urls := range [][]string{{a.URL, b.URL}, {a.URL, c.URL}} {
+		d := newTestMultiSourceDownloader(t, urls,
+			WithFileName(filepath.Join(dir, "mismatch.bin")),
+			WithBaseDir(filepath.Join(dir, "cache")),
+		)
+		if err := d.Start(); !errors.Is(err, ErrSourceMismatch) {
+			t.Errorf("Start() error = %v, want %v", err, ErrSourceMismatch)
+		}
+	}
+}
+
+// TestMultiSourceUnreachable 测试部分镜像不可用时使用剩余的镜像下载
+func TestMultiSourceUnreachable(t *testing.T) {
+	data := testData(32 * 1024)
+	good := newMirrorServer(data, "", -1, 0)
+	defer good.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "partial_mirrors.bin")
+	d := newTestMultiSourceDownloader(t, []string{"http://127.0.0.1:1/file", good.URL},
+		WithFileName(target),
+		WithBaseDir(filepath.Join(dir, "cache")),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("downloaded file mismatch, err = %v", err)
+	}
+}
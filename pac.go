@@ -0,0 +1,611 @@
+package dl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	// pacCacheTTL PAC脚本结果的缓存时间
+	pacCacheTTL = 5 * time.Minute
+	// pacCacheSize 缓存的结果数上限，超过时清空缓存
+	pacCacheSize = 1024
+	// pacMaxScriptSize PAC脚本的大小上限
+	pacMaxScriptSize = 1 << 20
+	// pacTimeout 下载PAC脚本和DNS查询的超时时间
+	pacTimeout = 10 * time.Second
+	// pacScriptTimeout 单次执行PAC脚本的时间上限，包括脚本中的DNS查询
+	pacScriptTimeout = 30 * time.Second
+	// pacMaxCallDepth PAC脚本函数调用的最大深度
+	pacMaxCallDepth = 1000
+	// pacProxyRetry 连接失败的代理在这段时间内不再使用
+	pacProxyRetry = 5 * time.Minute
+)
+
+// errPACTimeout PAC脚本执行超时
+var errPACTimeout = errors.New("PAC script timed out")
+
+// PAC 代理自动配置（Proxy Auto-Config）脚本，按请求的地址选择代理服务器
+//
+// 脚本由JavaScript引擎（goja）执行，支持完整的ES5语法（循环、数组、正则表达式、switch等）和标准的PAC函数：
+// isPlainHostName、dnsDomainIs、localHostOrDomainIs、isResolvable、isInNet、dnsResolve、convert_addr、
+// myIpAddress、dnsDomainLevels、shExpMatch、weekdayRange、dateRange、timeRange。
+// 每次执行使用独立的脚本环境，脚本不应依赖在多次调用之间保存的全局变量。
+// 同一地址的结果会缓存5分钟，PAC 可以在多个下载器之间共享
+type PAC struct {
+	program *goja.Program
+	vms     sync.Pool // 空闲的 *pacVM，脚本环境不能并发使用
+
+	mu     sync.Mutex
+	cache  map[string]pacCacheEntry // 键为传给 FindProxyForURL 的地址
+	failed map[string]time.Time     // 连接失败的代理地址和恢复使用的时间
+
+	lookup  func(host string) []net.IP
+	now     func() time.Time
+	timeout time.Duration // 单次执行脚本的时间上限
+}
+
+// pacVM 执行过PAC脚本的JavaScript环境
+type pacVM struct {
+	vm   *goja.Runtime
+	find goja.Callable
+}
+
+// pacCacheEntry 缓存的PAC脚本结果
+type pacCacheEntry struct {
+	result  string
+	expires time.Time
+}
+
+// WithPAC 使用PAC脚本为每个请求选择代理服务器，与 WithProxy、WithSystemProxy 同时使用时以最后一个为准
+//
+// 脚本返回多个代理（例如 "PROXY a:3128; PROXY b:3128; DIRECT"）时，无法连接的代理会被跳过，
+// 请求立即改用列表中的下一项，之后5分钟内不再尝试该代理
+//
+// 示例:
+//
+//	pac, err := LoadPAC("http://wpad.corp.example.com/proxy.pac")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	dl, err := NewDownloader(url, WithPAC(pac))
+func WithPAC(pac *PAC) OptionFunc {
+	return func(o *Options) {
+		if pac != nil {
+			o.Proxy = pac.Proxy
+			o.pac = pac
+		}
+	}
+}
+
+// ParsePAC 解析PAC脚本
+//
+// 参数:
+//
+//	script - PAC脚本内容，必须定义 FindProxyForURL(url, host) 函数
+//
+// 返回:
+//
+//	*PAC - 解析后的PAC脚本
+//	error - 脚本有语法错误或没有定义 FindProxyForURL 时返回 ErrInvalidPAC
+func ParsePAC(script string) (*PAC, error) {
+	program, err := goja.Compile("proxy.pac", script, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPAC, err)
+	}
+
+	p := &PAC{
+		program: program,
+		cache:   make(map[string]pacCacheEntry),
+		failed:  make(map[string]time.Time),
+		lookup:  lookupIPv4,
+		now:     time.Now,
+		timeout: pacScriptTimeout,
+	}
+	vm, err := p.newVM()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPAC, err)
+	}
+	p.vms.Put(vm)
+	return p, nil
+}
+
+// newVM 创建JavaScript环境，注册内置函数并执行脚本的顶层代码
+func (p *PAC) newVM() (*pacVM, error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(pacMaxCallDepth)
+	for name, fn := range p.builtins() {
+		vm.Set(name, fn)
+	}
+	if _, err := p.run(vm, func() (goja.Value, error) { return vm.RunProgram(p.program) }); err != nil {
+		return nil, err
+	}
+	find, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, errors.New("FindProxyForURL is not defined")
+	}
+	return &pacVM{vm: vm, find: find}, nil
+}
+
+// run 在时间上限内执行脚本，超时时中断执行并返回错误
+func (p *PAC) run(vm *goja.Runtime, fn func() (goja.Value, error)) (goja.Value, error) {
+	timer := time.AfterFunc(p.timeout, func() { vm.Interrupt(errPACTimeout) })
+	v, err := fn()
+	if !timer.Stop() {
+		// 中断可能在执行结束之后才生效，返回 errPACTimeout 的环境不再复用
+		return nil, fmt.Errorf("%w after %v", errPACTimeout, p.timeout)
+	}
+	return v, err
+}
+
+// LoadPAC 从文件或URL读取PAC脚本
+//
+// 参数:
+//
+//	location - 本地文件路径，或 http://、https://、file:// 开头的地址
+//
+// 返回:
+//
+//	*PAC - 解析后的PAC脚本
+//	error - 读取失败或脚本无效时返回错误
+func LoadPAC(location string) (*PAC, error) {
+	var r io.Reader
+	switch {
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		// PAC脚本直接下载，不使用下载器的代理等配置
+		client := &http.Client{Timeout: pacTimeout}
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download PAC: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to download PAC: %s", resp.Status)
+		}
+		r = resp.Body
+	default:
+		path := location
+		if strings.HasPrefix(location, "file://") {
+			u, err := url.Parse(location)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open PAC: %w", err)
+			}
+			path = u.Path
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PAC: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, pacMaxScriptSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PAC: %w", err)
+	}
+	if len(data) > pacMaxScriptSize {
+		return nil, fmt.Errorf("%w: script exceeds %d bytes", ErrInvalidPAC, pacMaxScriptSize)
+	}
+	return ParsePAC(string(data))
+}
+
+// FindProxy 执行 FindProxyForURL，返回脚本的原始结果，例如 "PROXY proxy:8080; DIRECT"
+//
+// 与浏览器一样，https 地址只把协议和主机传给脚本，不暴露路径和查询参数
+func (p *PAC) FindProxy(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	target := *u
+	target.Fragment, target.RawFragment, target.User = "", "", nil
+	if target.Scheme == "https" {
+		target.Path, target.RawPath, target.RawQuery = "/", "", ""
+	}
+	key := target.String()
+
+	p.mu.Lock()
+	e, ok := p.cache[key]
+	p.mu.Unlock()
+	now := time.Now()
+	if ok && now.Before(e.expires) {
+		return e.result, nil
+	}
+
+	// 执行脚本时不持有锁，脚本中的DNS查询不会阻塞其他地址的查询和缓存命中
+	vm, _ := p.vms.Get().(*pacVM)
+	if vm == nil {
+		if vm, err = p.newVM(); err != nil {
+			return "", fmt.Errorf("failed to evaluate PAC: %w", err)
+		}
+	}
+	v, err := p.run(vm.vm, func() (goja.Value, error) {
+		return vm.find(goja.Undefined(), vm.vm.ToValue(key), vm.vm.ToValue(strings.ToLower(u.Hostname())))
+	})
+	if !errors.Is(err, errPACTimeout) {
+		p.vms.Put(vm)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate PAC: %w", err)
+	}
+	result := ""
+	if !goja.IsUndefined(v) && !goja.IsNull(v) {
+		result = v.String()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= pacCacheSize {
+		p.cache = make(map[string]pacCacheEntry)
+	}
+	p.cache[key] = pacCacheEntry{result: result, expires: now.Add(pacCacheTTL)}
+	return result, nil
+}
+
+// Proxy 返回请求应使用的代理服务器，可用作 http.Transport.Proxy
+//
+// 脚本返回多个代理时按顺序使用第一个支持的（PROXY、HTTP、HTTPS、SOCKS、SOCKS5）且最近没有连接失败的代理，
+// 选中 DIRECT 或脚本返回空字符串时直接连接。通过 WithPAC 使用时，连接代理失败会记录下来并立即改用列表中的下一项
+func (p *PAC) Proxy(req *http.Request) (*url.URL, error) {
+	if c, ok := req.Context().Value(pacChoiceKey{}).(pacChoice); ok && c.pac == p {
+		return c.proxy, nil
+	}
+	proxies, err := p.proxies(req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	return p.choose(proxies), nil
+}
+
+// proxies 执行脚本并解析代理列表
+func (p *PAC) proxies(rawURL string) ([]*url.URL, error) {
+	result, err := p.FindProxy(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return parsePACResult(result)
+}
+
+// choose 返回列表中第一个没有处于失败状态的项，nil表示直接连接；所有代理都失败时仍然使用第一项
+func (p *PAC) choose(proxies []*url.URL) *url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, proxy := range proxies {
+		if proxy == nil || !now.Before(p.failed[proxy.Host]) {
+			return proxy
+		}
+	}
+	return proxies[0]
+}
+
+// markFailed 记录连接失败的代理，代理已经处于失败状态时返回false
+func (p *PAC) markFailed(proxy *url.URL) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Before(p.failed[proxy.Host]) {
+		return false
+	}
+	if len(p.failed) >= pacCacheSize {
+		p.failed = make(map[string]time.Time)
+	}
+	p.failed[proxy.Host] = now.Add(pacProxyRetry)
+	return true
+}
+
+// pacChoiceKey 请求上下文中保存 pacTransport 选中的代理的键
+type pacChoiceKey struct{}
+
+// pacChoice pacTransport 为一次请求选中的代理，nil表示直接连接
+type pacChoice struct {
+	pac   *PAC
+	proxy *url.URL
+}
+
+// pacTransport 按PAC脚本返回的代理列表依次尝试，连接代理失败时改用下一项
+type pacTransport struct {
+	*http.Transport
+	pac *PAC
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *pacTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxies, err := t.pac.proxies(req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	for {
+		proxy := t.pac.choose(proxies)
+		ctx := context.WithValue(req.Context(), pacChoiceKey{}, pacChoice{pac: t.pac, proxy: proxy})
+		resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+		// 只有连接代理失败时才换下一项，请求体可能已经被读取的请求不重发
+		var opErr *net.OpError
+		if err == nil || proxy == nil || (req.Body != nil && req.Body != http.NoBody) || req.Context().Err() != nil ||
+			!errors.As(err, &opErr) || opErr.Op != "proxyconnect" || !t.pac.markFailed(proxy) {
+			return resp, err
+		}
+	}
+}
+
+// parsePACResult 解析 FindProxyForURL 的结果，返回按顺序排列的代理列表，nil项表示直接连接
+func parsePACResult(result string) ([]*url.URL, error) {
+	if strings.TrimSpace(result) == "" {
+		return []*url.URL{nil}, nil
+	}
+	var proxies []*url.URL
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			// DIRECT 总是可用，之后的项不会被使用
+			return append(proxies, nil), nil
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			// SOCKS4 等不支持的类型
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		proxies = append(proxies, &url.URL{Scheme: scheme, Host: fields[1]})
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no supported proxy in PAC result %q", result)
+	}
+	return proxies, nil
+}
+
+// builtins 返回PAC脚本可以调用的内置函数
+func (p *PAC) builtins() map[string]any {
+	return map[string]any{
+		"isPlainHostName": func(host string) bool {
+			return !strings.Contains(host, ".")
+		},
+		"dnsDomainIs": func(host, domain string) bool {
+			return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+		},
+		"localHostOrDomainIs": func(host, hostdom string) bool {
+			host, hostdom = strings.ToLower(host), strings.ToLower(hostdom)
+			if strings.Contains(host, ".") {
+				return host == hostdom
+			}
+			return host == hostdom || strings.HasPrefix(hostdom, host+".")
+		},
+		"isResolvable": func(host string) bool {
+			return len(p.lookup(host)) > 0
+		},
+		"dnsResolve": func(host string) any {
+			if ips := p.lookup(host); len(ips) > 0 {
+				return ips[0].String()
+			}
+			return nil
+		},
+		"isInNet": func(host, pattern, mask string) bool {
+			ips := p.lookup(host)
+			ip := net.ParseIP(pattern).To4()
+			m := net.ParseIP(mask).To4()
+			if len(ips) == 0 || ip == nil || m == nil {
+				return false
+			}
+			return ips[0].Mask(net.IPMask(m)).Equal(ip.Mask(net.IPMask(m)))
+		},
+		"convert_addr": func(addr string) float64 {
+			ip := net.ParseIP(addr).To4()
+			if ip == nil {
+				return 0
+			}
+			return float64(uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]))
+		},
+		"myIpAddress":     myIPAddress,
+		"dnsDomainLevels": func(host string) int { return strings.Count(host, ".") },
+		"shExpMatch":      shExpMatch,
+		"weekdayRange": func(args ...goja.Value) bool {
+			args, now := p.timeArgs(args)
+			return weekdayRange(args, now)
+		},
+		"dateRange": func(args ...goja.Value) bool {
+			args, now := p.timeArgs(args)
+			return dateRange(args, now)
+		},
+		"timeRange": func(args ...goja.Value) bool {
+			args, now := p.timeArgs(args)
+			return timeRange(args, now)
+		},
+		"alert": func(...goja.Value) {},
+	}
+}
+
+// timeArgs 返回时间函数的参数和当前时间，最后一个参数为 "GMT" 时使用UTC时间，否则使用本地时间
+func (p *PAC) timeArgs(args []goja.Value) ([]goja.Value, time.Time) {
+	now := p.now()
+	if n := len(args); n > 0 && strings.EqualFold(args[n-1].String(), "GMT") {
+		return args[:n-1], now.UTC()
+	}
+	return args, now.Local()
+}
+
+var (
+	// pacWeekdays weekdayRange 使用的星期缩写，顺序与 time.Weekday 一致
+	pacWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	// pacMonths dateRange 使用的月份缩写
+	pacMonths = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+)
+
+// inRange 判断 v 是否在闭区间 [start, end] 内，start 大于 end 时区间跨过周期的末尾（例如 FRI 到 MON）
+func inRange(start, end, v int) bool {
+	if start <= end {
+		return start <= v && v <= end
+	}
+	return v >= start || v <= end
+}
+
+// weekdayRange 实现 weekdayRange(wd1[, wd2])，只有一个参数时判断是否为这一天
+func weekdayRange(args []goja.Value, now time.Time) bool {
+	if len(args) == 0 || len(args) > 2 {
+		return false
+	}
+	start := slices.Index(pacWeekdays, strings.ToUpper(args[0].String()))
+	end := start
+	if len(args) == 2 {
+		end = slices.Index(pacWeekdays, strings.ToUpper(args[1].String()))
+	}
+	if start < 0 || end < 0 {
+		return false
+	}
+	return inRange(start, end, int(now.Weekday()))
+}
+
+// dateRange 实现 dateRange，参数可以是日（1-31）、月份缩写和四位年份
+//
+// 一个参数时判断当前日期的对应部分；多个参数时前一半是起始日期，后一半是结束日期，
+// 两部分按日、月、年的顺序包含相同的字段，例如 dateRange(1, "JUN", 15, "AUG")
+func dateRange(args []goja.Value, now time.Time) bool {
+	const (
+		year = iota
+		month
+		day
+	)
+	current := [...]int{now.Year(), int(now.Month()), now.Day()}
+	weight := [...]int{10000, 100, 1}
+
+	fields := make([]int, len(args))
+	values := make([]int, len(args))
+	for i, arg := range args {
+		if m := slices.Index(pacMonths, strings.ToUpper(arg.String())); m >= 0 {
+			fields[i], values[i] = month, m+1
+			continue
+		}
+		n := arg.ToFloat()
+		switch {
+		case n != math.Trunc(n) || n < 1:
+			return false
+		case n <= 31:
+			fields[i], values[i] = day, int(n)
+		default:
+			fields[i], values[i] = year, int(n)
+		}
+	}
+
+	if len(args) == 1 {
+		return current[fields[0]] == values[0]
+	}
+	half := len(args) / 2
+	if len(args)%2 != 0 || half > 3 {
+		return false
+	}
+	var start, end, cur int
+	for i := 0; i < half; i++ {
+		f := fields[i]
+		if fields[half+i] != f || (i > 0 && f >= fields[i-1]) {
+			return false
+		}
+		start += values[i] * weight[f]
+		end += values[half+i] * weight[f]
+		cur += current[f] * weight[f]
+	}
+	if fields[half-1] == year && start > end {
+		return false // 年份不会循环
+	}
+	return inRange(start, end, cur)
+}
+
+// timeRange 实现 timeRange(hour)、timeRange(h1, h2)、timeRange(h1, m1, h2, m2) 和 timeRange(h1, m1, s1, h2, m2, s2)
+//
+// 时间段包含起始时间，不包含结束时间，例如 timeRange(9, 17) 在 9:00 到 16:59:59 之间成立；
+// 起始时间晚于结束时间时表示跨过午夜
+func timeRange(args []goja.Value, now time.Time) bool {
+	values := make([]int, len(args))
+	for i, arg := range args {
+		values[i] = int(arg.ToInteger())
+	}
+	cur := now.Hour()*3600 + now.Minute()*60 + now.Second()
+	seconds := func(v []int) int {
+		t := 0
+		for i, unit := range []int{3600, 60, 1}[:len(v)] {
+			t += v[i] * unit
+		}
+		return t
+	}
+	switch len(values) {
+	case 1:
+		return now.Hour() == values[0]
+	case 2, 4, 6:
+		half := len(values) / 2
+		return inRange(seconds(values[:half]), seconds(values[half:])-1, cur)
+	}
+	return false
+}
+
+// shExpMatch 按shell通配符匹配字符串，* 匹配任意字符（包括 /），? 匹配单个字符
+func shExpMatch(s, pattern string) bool {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(")$")
+	return regexp.MustCompile(b.String()).MatchString(s)
+}
+
+// lookupIPv4 解析主机名的IPv4地址，主机名本身是IP地址时直接返回
+func lookupIPv4(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return []net.IP{ip4}
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pacTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil
+	}
+	return ips
+}
+
+// myIPAddress 返回本机访问外网使用的IPv4地址，无法确定时返回 127.0.0.1
+func myIPAddress() string {
+	// 连接UDP地址不会发送数据，只用于让系统选择出口地址
+	conn, err := net.Dial("udp4", "198.51.100.1:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return "127.0.0.1"
+}
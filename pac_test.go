@@ -0,0 +1,533 @@
+package dl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestPACExpressions 测试PAC脚本的表达式求值和内置函数
+func TestPACExpressions(t *testing.T) {
+	const target = "http://www.example.com:8080/path/file.bin?x=1"
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`isPlainHostName(host)`, "false"},
+		{`isPlainHostName("intranet")`, "true"},
+		{`dnsDomainIs(host, ".example.com")`, "true"},
+		{`dnsDomainIs(host, ".example.org")`, "false"},
+		{`localHostOrDomainIs("www", "www.example.com")`, "true"},
+		{`localHostOrDomainIs("www.other.com", "www.example.com")`, "false"},
+		{`isInNet("10.1.2.3", "10.0.0.0", "255.0.0.0")`, "true"},
+		{`isInNet("192.168.1.1", "10.0.0.0", "255.0.0.0")`, "false"},
+		{`dnsResolve("127.0.0.1")`, "127.0.0.1"},
+		{`convert_addr("1.2.3.4")`, "16909060"},
+		{`dnsDomainLevels(host)`, "2"},
+		{`shExpMatch(url, "*/path/*.bin*")`, "true"},
+		{`shExpMatch(host, "*.example.?om")`, "true"},
+		{`shExpMatch(host, "example.*")`, "false"},
+		{`url.substring(0, 5) == "http:"`, "true"},
+		{`url.substr(-5)`, "n?x=1"},
+		{`host.toUpperCase().indexOf("EXAMPLE")`, "4"},
+		{`host.slice(-3) + host[0] + host.charAt(1)`, "comww"},
+		{`url.length > 10 ? "long" : "short"`, "long"},
+		{`1 + 2 * 3 - 4 / 2 + 7 % 4`, "8"},
+		{`"a" + 1 + 2`, "a12"},
+		{`1 + 2 + "a"`, "3a"},
+		{`1 == "1"`, "true"},
+		{`1 === "1"`, "false"},
+		{`null == undefined`, "true"},
+		{`"b" > "a" && 2 >= 2 && !(1 < 0)`, "true"},
+		{`!"" && (null || "x")`, "x"},
+		{`-(-3) + +"4"`, "7"},
+		{`'single \'quoted\'\x21'`, "single 'quoted'!"},
+		{`0x10 + 1.5`, "17.5"},
+		{`/^www\.(example|test)\.com$/.test(host)`, "true"},
+		{`host.split(".").reverse().join(",")`, "com,example,www"},
+		{`[1, 2, 3].map(function (x) { return x * 2; }).indexOf(6)`, "2"},
+		{`url.replace(/\?.*$/, "").match(/[^/]+$/)[0]`, "file.bin"},
+		{`typeof alert`, "function"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p, err := ParsePAC(fmt.Sprintf("function FindProxyForURL(url, host) { return \"\" + (%s); }", tt.expr))
+			if err != nil {
+				t.Fatalf("ParsePAC() error = %v", err)
+			}
+			got, err := p.FindProxy(target)
+			if err != nil {
+				t.Fatalf("FindProxy() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPACScripts 测试PAC脚本的语句、作用域和错误
+func TestPACScripts(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "helpers and else if",
+			script: `
+				/* 公司网络 */
+				var corp = "PROXY corp:3128";
+				function FindProxyForURL(url, host) {
+					if (isPlainHostName(host)) return "DIRECT";
+					else if (internal(host)) {
+						return corp;
+					} else
+						return fallback();
+				}
+				// 函数声明在使用之后
+				function internal(h) { return dnsDomainIs(h, ".corp.example.com"); }
+				function fallback() { let p = "PROXY "; p += "edge:8080"; return p + "; DIRECT" }`,
+			url:  "http://git.corp.example.com/repo",
+			want: "PROXY corp:3128",
+		},
+		{
+			name: "fallback",
+			script: `function FindProxyForURL(url, host) {
+				if (isPlainHostName(host)) return "DIRECT"
+				return host == "x" ? "DIRECT" : "PROXY edge:8080; DIRECT"
+			}`,
+			url:  "http://www.example.com/",
+			want: "PROXY edge:8080; DIRECT",
+		},
+		{
+			name:   "https path hidden",
+			script: `function FindProxyForURL(url, host) { return url; }`,
+			url:    "https://user:pw@example.com/secret?token=1#frag",
+			want:   "https://example.com/",
+		},
+		{
+			name:   "no return value",
+			script: `function FindProxyForURL(url, host) { var unused = 1; }`,
+			url:    "http://example.com/",
+			want:   "",
+		},
+		{
+			name:    "undefined variable",
+			script:  `function FindProxyForURL(url, host) { return proxy; }`,
+			url:     "http://example.com/",
+			wantErr: true,
+		},
+		{
+			name:    "unbounded recursion",
+			script:  `function FindProxyForURL(url, host) { return FindProxyForURL(url, host); }`,
+			url:     "http://example.com/",
+			wantErr: true,
+		},
+		{
+			name: "loops, arrays and switch",
+			script: `
+				var direct = ["*.corp.example.com", "10.*", "localhost"];
+				function FindProxyForURL(url, host) {
+					for (var i = 0; i < direct.length; i++) {
+						if (shExpMatch(host, direct[i])) return "DIRECT";
+					}
+					switch (url.substring(0, url.indexOf(":"))) {
+					case "ftp":
+						return "PROXY ftp-proxy:2121";
+					case "https":
+						return "PROXY secure:3128; DIRECT";
+					default:
+						return "PROXY web:8080";
+					}
+				}`,
+			url:  "https://www.example.com/a.zip",
+			want: "PROXY secure:3128; DIRECT",
+		},
+		{
+			name:    "unknown function",
+			script:  `function FindProxyForURL(url, host) { return isResolvableEx(host) ? "DIRECT" : "PROXY a:1"; }`,
+			url:     "http://example.com/",
+			wantErr: true,
+		},
+		{
+			name:    "infinite loop",
+			script:  `function FindProxyForURL(url, host) { while (true) {} }`,
+			url:     "http://example.com/",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePAC(tt.script)
+			if err != nil {
+				t.Fatalf("ParsePAC() error = %v", err)
+			}
+			p.timeout = 200 * time.Millisecond
+			got, err := p.FindProxy(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindProxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FindProxy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParsePACErrors 测试无效的PAC脚本
+func TestParsePACErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"no FindProxyForURL", `function findProxy(url, host) { return "DIRECT"; }`},
+		{"unterminated string", `function FindProxyForURL(url, host) { return "DIRECT; }`},
+		{"unclosed block", `function FindProxyForURL(url, host) { return "DIRECT";`},
+		{"not a function", `var FindProxyForURL = "DIRECT";`},
+		{"top-level error", `var p = missing(); function FindProxyForURL(url, host) { return p; }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePAC(tt.script); !errors.Is(err, ErrInvalidPAC) {
+				t.Errorf("ParsePAC() error = %v, want %v", err, ErrInvalidPAC)
+			}
+		})
+	}
+}
+
+// TestParsePACResult 测试解析 FindProxyForURL 的结果
+func TestParsePACResult(t *testing.T) {
+	tests := []struct {
+		result  string
+		want    string // 按顺序排列的代理，DIRECT 表示直接连接
+		wantErr bool
+	}{
+		{"DIRECT", "DIRECT", false},
+		{"", "DIRECT", false},
+		{"PROXY proxy.example.com:3128; DIRECT", "http://proxy.example.com:3128 DIRECT", false},
+		{"  HTTPS secure.example.com:443 ", "https://secure.example.com:443", false},
+		{"PROXY a:3128; PROXY b:3128", "http://a:3128 http://b:3128", false},
+		{"SOCKS4 old:1080; SOCKS5 new:1080", "socks5://new:1080", false},
+		{"SOCKS4 old:1080; direct; PROXY unused:80", "DIRECT", false},
+		{"SOCKS4 old:1080", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.result, func(t *testing.T) {
+			proxies, err := parsePACResult(tt.result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePACResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, u := range proxies {
+				if u == nil {
+					got = append(got, "DIRECT")
+				} else {
+					got = append(got, u.String())
+				}
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("parsePACResult() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPACCache 测试同一地址的结果被缓存，不重复执行脚本
+func TestPACCache(t *testing.T) {
+	p, err := ParsePAC(`function FindProxyForURL(url, host) { return "PROXY " + dnsResolve(host) + ":8080"; }`)
+	if err != nil {
+		t.Fatalf("ParsePAC() error = %v", err)
+	}
+	var calls atomic.Int32
+	p.lookup = func(string) []net.IP {
+		return []net.IP{net.IPv4(10, 0, 0, byte(calls.Add(1)))}
+	}
+
+	for _, u := range []string{
+		"https://a.example.com/file?part=1",
+		"https://a.example.com/file?part=2", // https 地址只按主机缓存
+		"http://b.example.com/file",
+		"http://b.example.com/file",
+	} {
+		if _, err := p.FindProxy(u); err != nil {
+			t.Fatalf("FindProxy(%s) error = %v", u, err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("script evaluated %d times, want 2", n)
+	}
+	if got, _ := p.FindProxy("https://a.example.com/other"); got != "PROXY 10.0.0.1:8080" {
+		t.Errorf("cached result = %q, want PROXY 10.0.0.1:8080", got)
+	}
+
+	// 过期的结果重新计算
+	for k, e := range p.cache {
+		e.expires = time.Now().Add(-time.Second)
+		p.cache[k] = e
+	}
+	if got, _ := p.FindProxy("http://b.example.com/file"); got != "PROXY 10.0.0.3:8080" {
+		t.Errorf("expired result = %q, want PROXY 10.0.0.3:8080", got)
+	}
+}
+
+// TestPACConcurrentEvaluation 测试脚本中缓慢的DNS查询不会阻塞其他地址的查询
+func TestPACConcurrentEvaluation(t *testing.T) {
+	p, err := ParsePAC(`function FindProxyForURL(url, host) { return isResolvable(host) ? "DIRECT" : "PROXY p:1"; }`)
+	if err != nil {
+		t.Fatalf("ParsePAC() error = %v", err)
+	}
+	release := make(chan struct{})
+	p.lookup = func(host string) []net.IP {
+		if host == "slow.example.com" {
+			<-release
+		}
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.FindProxy("http://slow.example.com/")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if got, err := p.FindProxy("http://fast.example.com/"); err != nil || got != "PROXY p:1" {
+		t.Errorf("FindProxy() = %q, %v; want PROXY p:1", got, err)
+	}
+	close(release)
+	<-done
+}
+
+// TestPACTimeFunctions 测试 weekdayRange、dateRange 和 timeRange
+func TestPACTimeFunctions(t *testing.T) {
+	// 2024-03-15 是星期五
+	now := time.Date(2024, time.March, 15, 14, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`weekdayRange("FRI", "GMT")`, true},
+		{`weekdayRange("MON", "FRI", "GMT")`, true},
+		{`weekdayRange("SAT", "SUN", "GMT")`, false},
+		{`weekdayRange("THU", "MON", "GMT")`, true},
+		{`weekdayRange("XYZ", "GMT")`, false},
+		{`dateRange(15, "GMT")`, true},
+		{`dateRange("MAR", "GMT")`, true},
+		{`dateRange(2024, "GMT")`, true},
+		{`dateRange(1, 14, "GMT")`, false},
+		{`dateRange("NOV", "APR", "GMT")`, true},
+		{`dateRange(1, "MAR", 15, "MAR", "GMT")`, true},
+		{`dateRange(16, "MAR", 20, "APR", "GMT")`, false},
+		{`dateRange("JAN", 2024, "JUN", 2024, "GMT")`, true},
+		{`dateRange(1, "JAN", 2023, 31, "DEC", 2023, "GMT")`, false},
+		{`dateRange(2025, 2020, "GMT")`, false},
+		{`dateRange("MAR", 15, "GMT")`, false},
+		{`timeRange(14, "GMT")`, true},
+		{`timeRange(9, 17, "GMT")`, true},
+		{`timeRange(9, 14, "GMT")`, false},
+		{`timeRange(22, 15, "GMT")`, true},
+		{`timeRange(14, 30, 14, 31, "GMT")`, true},
+		{`timeRange(14, 30, 16, 14, 30, 20, "GMT")`, false},
+		{`timeRange(1, 2, 3, "GMT")`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p, err := ParsePAC(fmt.Sprintf("function FindProxyForURL(url, host) { return \"\" + %s; }", tt.expr))
+			if err != nil {
+				t.Fatalf("ParsePAC() error = %v", err)
+			}
+			p.now = func() time.Time { return now }
+			got, err := p.FindProxy("http://example.com/")
+			if err != nil {
+				t.Fatalf("FindProxy() error = %v", err)
+			}
+			if got != fmt.Sprint(tt.want) {
+				t.Errorf("%s = %s, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+
+	// 没有 "GMT" 时使用本地时间
+	p, _ := ParsePAC(`function FindProxyForURL(url, host) { return "" + timeRange(14); }`)
+	p.now = func() time.Time { return time.Date(2024, time.March, 15, 14, 0, 0, 0, time.Local).UTC() }
+	if got, _ := p.FindProxy("http://example.com/"); got != "true" {
+		t.Errorf("timeRange(14) in local time = %s, want true", got)
+	}
+}
+
+// TestPACProxy 测试按PAC脚本为不同主机选择不同的本地代理
+func TestPACProxy(t *testing.T) {
+	data := testData(48 * 1024)
+	const user, pass = "alice", "s3cret"
+
+	alpha, alphaServed := createProxyServer(data, user, pass)
+	defer alpha.Close()
+	beta, betaServed := createProxyServer(data, user, pass)
+	defer beta.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer origin.Close()
+
+	script := fmt.Sprintf(`function FindProxyForURL(url, host) {
+		if (isPlainHostName(host) || dnsDomainIs(host, ".direct.test"))
+			return "DIRECT";
+		if (shExpMatch(host, "*.alpha.test"))
+			return "PROXY %s; DIRECT";
+		return "SOCKS4 127.0.0.1:1; PROXY %s";
+	}`, strings.TrimPrefix(alpha.URL, "http://"), strings.TrimPrefix(beta.URL, "http://"))
+
+	dir := t.TempDir()
+	pacFile := filepath.Join(dir, "proxy.pac")
+	os.WriteFile(pacFile, []byte(script), FilePerm)
+	pacServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		fmt.Fprint(w, script)
+	}))
+	defer pacServer.Close()
+
+	directURL := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1) + "/file.bin"
+
+	tests := []struct {
+		name      string
+		location  string
+		url       string
+		wantAlpha bool
+		wantBeta  bool
+	}{
+		{"file alpha", pacFile, "http://files.alpha.test/file.bin", true, false},
+		{"url beta", pacServer.URL + "/proxy.pac", "http://files.beta.test/file.bin", false, true},
+		{"file URL direct", "file://" + filepath.ToSlash(pacFile), directURL, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alphaServed.Store(0)
+			betaServed.Store(0)
+			pac, err := LoadPAC(tt.location)
+			if err != nil {
+				t.Fatalf("LoadPAC() error = %v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "pac.bin")
+			err = newTestDownloader(t, tt.url,
+				WithFileName(path),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+				WithPAC(pac),
+				WithProxyAuth(user, pass),
+			).Start()
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			if (alphaServed.Load() > 0) != tt.wantAlpha || (betaServed.Load() > 0) != tt.wantBeta {
+				t.Errorf("alpha served %d, beta served %d; want alpha %v, beta %v",
+					alphaServed.Load(), betaServed.Load(), tt.wantAlpha, tt.wantBeta)
+			}
+		})
+	}
+
+	if _, err := LoadPAC(filepath.Join(dir, "missing.pac")); err == nil {
+		t.Error("LoadPAC() of a missing file succeeded")
+	}
+}
+
+// TestPACFailover 测试连接代理失败时按脚本返回的顺序改用下一个代理或直接连接
+func TestPACFailover(t *testing.T) {
+	data := testData(32 * 1024)
+	const user, pass = "alice", "s3cret"
+	live, liveServed := createProxyServer(data, user, pass)
+	defer live.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer origin.Close()
+
+	// 关闭监听得到一个无法连接的地址
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+	liveAddr := strings.TrimPrefix(live.URL, "http://")
+
+	tests := []struct {
+		name     string
+		result   string
+		wantLive bool
+	}{
+		{"next proxy", "PROXY " + dead + "; PROXY " + liveAddr, true},
+		{"direct", "SOCKS " + dead + "; DIRECT", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			liveServed.Store(0)
+			pac, err := ParsePAC(fmt.Sprintf("function FindProxyForURL(url, host) { return %q; }", tt.result))
+			if err != nil {
+				t.Fatalf("ParsePAC() error = %v", err)
+			}
+			path := filepath.Join(t.TempDir(), "failover.bin")
+			err = newTestDownloader(t, origin.URL+"/file.bin",
+				WithFileName(path),
+				WithBaseDir(filepath.Join(filepath.Dir(path), "cache")),
+				WithConcurrency(2),
+				WithPAC(pac),
+				WithProxyAuth(user, pass),
+			).Start()
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+				t.Fatal("downloaded file mismatch")
+			}
+			if (liveServed.Load() > 0) != tt.wantLive {
+				t.Errorf("live proxy served %d requests, want served = %v", liveServed.Load(), tt.wantLive)
+			}
+			if !time.Now().Before(pac.failed[dead]) {
+				t.Errorf("unreachable proxy %s was not marked as failed", dead)
+			}
+		})
+	}
+}
+
+// FuzzParsePAC 测试任意脚本都不会使解析和执行崩溃
+func FuzzParsePAC(f *testing.F) {
+	f.Add(`function FindProxyForURL(url, host) { if (isInNet(host, "10.0.0.0", "255.0.0.0")) return "DIRECT"; return "PROXY p:1"; }`)
+	f.Add(`var a = 1; function FindProxyForURL(u, h) { a += 1; return u.substr(-3) + h[a] + (a > 2 ? "x" : 'y\x41'); }`)
+	f.Add(`function FindProxyForURL(url, host) { return url.slice(-100, 5).indexOf("", 99) + shExpMatch(host, "*?*"); }`)
+	f.Fuzz(func(t *testing.T, script string) {
+		p, err := ParsePAC(script)
+		if err != nil {
+			return
+		}
+		p.lookup = func(string) []net.IP { return nil }
+		result, err := p.FindProxy("http://www.example.com/file.bin")
+		if err == nil {
+			parsePACResult(result)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package dl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// queueVersion 队列文件格式版本
+const queueVersion = 1
+
+// jobRecord 持久化到队列文件中的任务记录
+//
+// 只保存可以序列化的配置项，HTTP客户端、回调等需要在恢复时通过
+// WithRestoreOptions 和 WithRestoreFunc 重新设置
+type jobRecord struct {
+	ID          int      `json:"id"`
+	URL         string   `json:"url"`
+	Mirrors     []string `json:"mirrors,omitempty"`
+	Priority    Priority `json:"priority"`
+	State       JobState `json:"state"`
+	Error       string   `json:"error,omitempty"`
+	FilePath    string   `json:"file_path"`
+	BaseDir     string   `json:"base_dir"`
+	Concurrency int      `json:"concurrency"`
+	Resume      bool     `json:"resume"`
+	AutoName    bool     `json:"auto_name,omitempty"`
+
+	Checksum     *Checksum    `json:"checksum,omitempty"`
+	Pieces       *PieceHashes `json:"pieces,omitempty"`
+	ExpectedSize int64        `json:"expected_size,omitempty"`
+	MaxSize      int64        `json:"max_size,omitempty"`
+
+	ExistingFile ExistingFilePolicy `json:"existing_file,omitempty"`
+	Conditional  bool               `json:"conditional,omitempty"`
+	RemoteTime   bool               `json:"remote_time,omitempty"`
+
+	// MaxRedirects 为nil时（旧版本的队列文件）使用默认值，0表示不跟随重定向
+	MaxRedirects       *int            `json:"max_redirects,omitempty"`
+	CrossHostRedirects CrossHostPolicy `json:"cross_host_redirects,omitempty"`
+}
+
+// queueFile 队列文件内容
+type queueFile struct {
+	Version int         `json:"version"`
+	Jobs    []jobRecord `json:"jobs"`
+}
+
+// MarshalText 实现encoding.TextMarshaler接口，队列文件中以字符串保存任务状态
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText 实现encoding.TextUnmarshaler接口
+func (s *JobState) UnmarshalText(text []byte) error {
+	for state := JobQueued; state <= JobCanceled; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown job state %q", text)
+}
+
+// WithRestoreOptions 设置恢复任务时附加的下载器配置
+// 用于补充无法持久化的配置项，例如 WithHTTPClient、WithProxy
+func WithRestoreOptions(opts ...OptionFunc) ManagerOptionFunc {
+	return func(o *ManagerOptions) {
+		o.RestoreOptions = append(o.RestoreOptions, opts...)
+	}
+}
+
+// WithRestoreFunc 设置任务恢复时的回调函数
+// 回调在任务重新调度之前执行，可用于为恢复的下载器设置进度等回调
+func WithRestoreFunc(f func(j *Job)) ManagerOptionFunc {
+	return func(o *ManagerOptions) {
+		o.OnRestore = f
+	}
+}
+
+// OpenManager 创建一个使用持久化队列的任务管理器
+//
+// 任务的URL、配置和状态会保存到队列文件中。进程重启后再次打开同一个
+// 队列文件时，未完成的任务会与缓存目录中的分片文件核对后自动恢复下载；
+// 已完成和已取消的任务不再保留（已取消任务的分片文件保留在缓存目录中），
+// 失败的任务保留其状态但不会自动重试。
+// 目标文件已经下载完成、但进程在记录完成状态之前退出的任务直接标记为已完成。
+//
+// 参数:
+//
+//	path - 队列文件路径，不存在时会自动创建
+//	opts - 可选的配置函数
+//
+// 返回:
+//
+//	*Manager - 任务管理器实例
+//	error - 读取队列文件或按恢复配置创建下载器失败时返回错误
+func OpenManager(path string, opts ...ManagerOptionFunc) (*Manager, error) {
+	m := NewManager(opts...)
+	m.queuePath = path
+
+	records, err := loadQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restored []*Job
+	var finished []jobRecord
+	inUse := make(map[string]bool)
+	for _, rec := range records {
+		if rec.ID > m.seq {
+			m.seq = rec.ID
+		}
+		switch rec.State {
+		case JobCompleted:
+			finished = append(finished, rec)
+			continue
+		case JobCanceled:
+			// 与 Job.Cancel 一致，保留已下载的分片文件
+			continue
+		}
+
+		j, err := m.restoreJob(rec)
+		if err != nil {
+			return nil, err
+		}
+		m.jobs = append(m.jobs, j)
+		inUse[j.d.getPartDir(j.d.options.FileName)] = true
+		if j.state == JobQueued {
+			restored = append(restored, j)
+		}
+	}
+
+	// 已完成的任务不再恢复，清理其残留的分片文件
+	for _, rec := range finished {
+		partDir := partDirOf(rec.BaseDir, filepath.Base(rec.FilePath))
+		if !inUse[partDir] {
+			_ = os.RemoveAll(partDir)
+			_ = removeIfEmpty(rec.BaseDir)
+		}
+	}
+
+	// 在调度之前执行恢复回调，保证回调能覆盖整个下载过程
+	if m.options.OnRestore != nil {
+		m.mu.Unlock()
+		for _, j := range restored {
+			m.options.OnRestore(j)
+		}
+		m.mu.Lock()
+	}
+
+	m.schedule()
+	return m, m.saveErr
+}
+
+// restoreJob 根据任务记录重建任务，并与缓存目录中的分片文件核对（需持有锁）
+func (m *Manager) restoreJob(rec jobRecord) (*Job, error) {
+	opts := append([]OptionFunc{}, m.options.RestoreOptions...)
+	opts = append(opts,
+		WithFileName(rec.FilePath),
+		WithBaseDir(rec.BaseDir),
+		WithConcurrency(rec.Concurrency),
+		WithResume(rec.Resume),
+		WithPieceHashes(rec.Pieces),
+		WithExpectedSize(rec.ExpectedSize),
+		WithMaxSize(rec.MaxSize),
+		WithExistingFilePolicy(rec.ExistingFile),
+		WithConditionalDownload(rec.Conditional),
+		WithRemoteTime(rec.RemoteTime),
+		WithCrossHostRedirects(rec.CrossHostRedirects),
+	)
+	if rec.MaxRedirects != nil {
+		opts = append(opts, WithMaxRedirects(*rec.MaxRedirects))
+	}
+	if rec.Checksum != nil {
+		opts = append(opts, WithChecksum(rec.Checksum.Algorithm, rec.Checksum.Sum))
+	}
+	var d *Downloader
+	var err error
+	if len(rec.Mirrors) > 1 {
+		d, err = NewMultiSourceDownloader(rec.Mirrors, opts...)
+	} else {
+		d, err = NewDownloader(rec.URL, opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore job %d: %w", rec.ID, err)
+	}
+	// 文件名由服务器决定的任务恢复后仍按服务器的响应确定文件名，保留已确定的目录和文件名作为初始值
+	d.autoName = rec.AutoName
+
+	j := &Job{
+		ID:       rec.ID,
+		m:        m,
+		d:        d,
+		priority: rec.Priority,
+		state:    JobQueued,
+		seq:      rec.ID,
+		leases:   make(map[*connLease]struct{}),
+		done:     make(chan struct{}),
+	}
+	d.scheduler = j
+
+	if rec.State == JobFailed {
+		j.finish(JobFailed, errors.New(rec.Error))
+		return j, nil
+	}
+
+	// 下载完成后、写入队列文件之前进程退出时，目标文件已经就绪，不再重新下载
+	partDir := d.getPartDir(d.options.FileName)
+	if d.finishedOnDisk() {
+		_ = os.RemoveAll(partDir)
+		_ = removeIfEmpty(rec.BaseDir)
+		j.finish(JobCompleted, nil)
+		return j, nil
+	}
+
+	// 未启用断点续传的任务会从头下载，残留的分片文件已无用
+	if !rec.Resume {
+		_ = os.RemoveAll(partDir)
+		_ = removeIfEmpty(rec.BaseDir)
+	}
+	return j, nil
+}
+
+// persist 将当前任务列表写入队列文件（需持有锁）
+func (m *Manager) persist() {
+	if m.queuePath == "" {
+		return
+	}
+
+	q := queueFile{Version: queueVersion, Jobs: make([]jobRecord, 0, len(m.jobs))}
+	for _, j := range m.jobs {
+		rec := jobRecord{
+			ID:          j.ID,
+			URL:         j.d.url,
+			Priority:    j.priority,
+			State:       j.state,
+			FilePath:    j.d.targetPath(),
+			AutoName:    j.d.autoName,
+			BaseDir:     j.d.options.BaseDir,
+			Concurrency: j.d.concurrency,
+			Resume:      j.d.resume,
+
+			Checksum:     j.d.options.Checksum,
+			Pieces:       j.d.options.Pieces,
+			ExpectedSize: j.d.options.ExpectedSize,
+			MaxSize:      j.d.options.MaxSize,
+
+			ExistingFile: j.d.options.ExistingFile,
+			Conditional:  j.d.options.Conditional,
+			RemoteTime:   j.d.options.RemoteTime,
+
+			MaxRedirects:       &j.d.options.MaxRedirects,
+			CrossHostRedirects: j.d.options.CrossHostRedirects,
+		}
+		if len(j.d.sources) > 1 {
+			for _, src := range j.d.sources {
+				rec.Mirrors = append(rec.Mirrors, src.url)
+			}
+		}
+		if j.err != nil {
+			rec.Error = j.err.Error()
+		}
+		q.Jobs = append(q.Jobs, rec)
+	}
+
+	m.saveErr = saveQueue(m.queuePath, &q)
+}
+
+// loadQueue 读取队列文件，文件不存在时返回空列表
+func loadQueue(path string) ([]jobRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue file: %w", err)
+	}
+
+	var q queueFile
+	if err = json.Unmarshal(data, &q); err != nil {
+		return nil, fmt.Errorf("failed to parse queue file: %w", err)
+	}
+	if q.Version != queueVersion {
+		return nil, fmt.Errorf("unsupported queue file version %d", q.Version)
+	}
+	return q.Jobs, nil
+}
+
+// saveQueue 写入队列文件，先写临时文件再重命名，避免进程崩溃时留下不完整的文件
+func saveQueue(path string, q *queueFile) error {
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode queue: %w", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), DirPerm); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, FilePerm); err != nil {
+		return fmt.Errorf("failed to write queue file: %w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace queue file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package dl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestOpenManagerRestore 测试重启后根据队列文件和残留的分片文件恢复下载
+func TestOpenManagerRestore(t *testing.T) {
+	const size = 64 * 1024
+	data := testData(size)
+
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			return
+		}
+		var start, end int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.WriteHeader(http.StatusPartialContent)
+		n, _ := w.Write(data[start : end+1])
+		served.Add(int64(n))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	queuePath := filepath.Join(dir, "queue.json")
+	cacheDir := filepath.Join(dir, "cache")
+	target := filepath.Join(dir, "restored.bin")
+
+	// 模拟进程退出前的状态：队列中有一个运行中的任务，两个分片各下载了一部分
+	const half, done = size / 2, 10 * 1024
+	partDir := filepath.Join(cacheDir, "restored.bin")
+	if err := os.MkdirAll(partDir, DirPerm); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		part := data[i*half : i*half+done]
+		if err := os.WriteFile(filepath.Join(partDir, fmt.Sprintf("restored.bin_%d", i)), part, FilePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 已完成任务残留的分片文件应被清理，已取消任务的分片文件保留
+	completedDir := filepath.Join(cacheDir, "completed.bin")
+	canceledDir := filepath.Join(cacheDir, "canceled.bin")
+	for _, d := range []string{completedDir, canceledDir} {
+		if err := os.MkdirAll(d, DirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := queueFile{Version: queueVersion, Jobs: []jobRecord{
+		{ID: 3, URL: server.URL, Priority: PriorityHigh, State: JobRunning, FilePath: target, BaseDir: cacheDir, Concurrency: 2, Resume: true},
+		{ID: 4, URL: server.URL, State: JobCompleted, FilePath: filepath.Join(dir, "completed.bin"), BaseDir: cacheDir, Concurrency: 2, Resume: true},
+		{ID: 5, URL: server.URL, State: JobCanceled, FilePath: filepath.Join(dir, "canceled.bin"), BaseDir: cacheDir, Concurrency: 2, Resume: true},
+	}}
+	if err := saveQueue(queuePath, &q); err != nil {
+		t.Fatal(err)
+	}
+
+	var restored []*Job
+	m, err := OpenManager(queuePath, WithRestoreFunc(func(j *Job) {
+		restored = append(restored, j)
+	}))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	m.Wait()
+
+	if len(restored) != 1 || restored[0].ID != 3 || restored[0].Priority() != PriorityHigh {
+		t.Fatalf("restored jobs = %v, want job 3", restored)
+	}
+	if err = restored[0].Wait(); err != nil {
+		t.Fatalf("restored job error = %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("failed to read restored file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("restored file content mismatch")
+	}
+	if served.Load() != size-2*done {
+		t.Errorf("served = %v bytes, want %v", served.Load(), size-2*done)
+	}
+	if _, err = os.Stat(completedDir); !os.IsNotExist(err) {
+		t.Error("part files of completed job were not removed")
+	}
+	if _, err = os.Stat(canceledDir); err != nil {
+		t.Errorf("part files of canceled job were removed: %v", err)
+	}
+
+	// 新加入的任务编号应接着队列文件中的最大编号
+	d := newTestDownloader(t, server.URL, WithFileName(filepath.Join(dir, "next.bin")), WithBaseDir(cacheDir))
+	if j := m.Add(d, PriorityNormal); j.ID != 6 {
+		t.Errorf("new job ID = %v, want 6", j.ID)
+	}
+	m.Wait()
+
+	// 队列文件应记录所有任务的最终状态
+	raw, err := os.ReadFile(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved queueFile
+	if err = json.Unmarshal(raw, &saved); err != nil {
+		t.Fatalf("queue file is not valid JSON: %v", err)
+	}
+	if len(saved.Jobs) != 2 {
+		t.Fatalf("saved jobs = %d, want 2", len(saved.Jobs))
+	}
+	for _, rec := range saved.Jobs {
+		if rec.State != JobCompleted {
+			t.Errorf("job %d state = %v, want %v", rec.ID, rec.State, JobCompleted)
+		}
+	}
+}
+
+// TestOpenManagerFinishedOnDisk 测试目标文件已经下载完成、但队列文件中仍为运行状态的任务不再重新下载
+func TestOpenManagerFinishedOnDisk(t *testing.T) {
+	data := testData(16 * 1024)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	finished := []byte("finished before the crash")
+
+	tests := []struct {
+		name     string
+		policy   ExistingFilePolicy
+		state    JobState
+		meta     bool // 是否写入与目标文件一致的附属文件
+		temp     bool // 是否残留临时文件
+		download bool // 是否应当重新下载
+	}{
+		{"error policy", ExistingError, JobRunning, false, false, false},
+		{"auto rename", ExistingAutoRename, JobQueued, false, false, false},
+		{"overwrite with sidecar", ExistingOverwrite, JobRunning, true, false, false},
+		{"overwrite without sidecar", ExistingOverwrite, JobRunning, false, false, true},
+		{"temp file left", ExistingOverwrite, JobRunning, true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			dir := t.TempDir()
+			target := filepath.Join(dir, "done.bin")
+			cacheDir := filepath.Join(dir, "cache")
+			partDir := partDirOf(cacheDir, "done.bin")
+			os.MkdirAll(partDir, DirPerm)
+			os.WriteFile(target, finished, FilePerm)
+			if tt.meta {
+				meta, _ := json.Marshal(fileMeta{URL: server.URL, Size: int64(len(finished))})
+				os.WriteFile(target+MetaSuffix, meta, FilePerm)
+			}
+			if tt.temp {
+				os.WriteFile(target+PartSuffix, data[:100], FilePerm)
+			}
+			queuePath := filepath.Join(dir, "queue.json")
+			q := queueFile{Version: queueVersion, Jobs: []jobRecord{
+				{ID: 1, URL: server.URL, State: tt.state, FilePath: target, BaseDir: cacheDir, Concurrency: 2, Resume: true, ExistingFile: tt.policy},
+			}}
+			if err := saveQueue(queuePath, &q); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := OpenManager(queuePath)
+			if err != nil {
+				t.Fatalf("OpenManager() error = %v", err)
+			}
+			m.Wait()
+			j := m.Jobs()[0]
+			if err := j.Wait(); err != nil || j.State() != JobCompleted {
+				t.Fatalf("job state = %v, error = %v; want completed", j.State(), err)
+			}
+			if (requests.Load() > 0) != tt.download {
+				t.Errorf("requests = %d, want download = %v", requests.Load(), tt.download)
+			}
+			want := finished
+			if tt.download {
+				want = data
+			}
+			if got, _ := os.ReadFile(target); !bytes.Equal(got, want) {
+				t.Error("target file content mismatch")
+			}
+			if _, err := os.Stat(partDir); !os.IsNotExist(err) {
+				t.Error("part directory was not removed")
+			}
+		})
+	}
+}
+
+// TestJobRecordRoundTrip 测试任务配置在写入队列文件后能原样恢复
+func TestJobRecordRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	queuePath := filepath.Join(dir, "queue.json")
+	rec := jobRecord{
+		ID:           1,
+		URL:          "http://files.example.com/a.bin",
+		Priority:     PriorityHigh,
+		State:        JobQueued,
+		FilePath:     filepath.Join(dir, "a.bin"),
+		BaseDir:      filepath.Join(dir, "cache"),
+		Concurrency:  3,
+		Resume:       true,
+		ExpectedSize: 1024,
+		MaxSize:      4096,
+		ExistingFile: ExistingAutoRename,
+		Conditional:  true,
+		RemoteTime:   true,
+
+		MaxRedirects:       new(int), // 0 表示不跟随重定向，不能被默认值替换
+		CrossHostRedirects: CrossHostDeny,
+	}
+
+	m := NewManager()
+	m.queuePath = queuePath
+	m.mu.Lock()
+	j, err := m.restoreJob(rec)
+	if err != nil {
+		m.mu.Unlock()
+		t.Fatalf("restoreJob() error = %v", err)
+	}
+	m.jobs = append(m.jobs, j)
+	m.persist()
+	m.mu.Unlock()
+	if m.saveErr != nil {
+		t.Fatalf("persist() error = %v", m.saveErr)
+	}
+
+	saved, err := loadQueue(queuePath)
+	if err != nil {
+		t.Fatalf("loadQueue() error = %v", err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("saved jobs = %d, want 1", len(saved))
+	}
+	want, _ := json.Marshal(rec)
+	got, _ := json.Marshal(saved[0])
+	if !bytes.Equal(got, want) {
+		t.Errorf("saved record = %s, want %s", got, want)
+	}
+
+	// 旧版本的队列文件没有重定向次数，恢复时使用默认值
+	rec.MaxRedirects = nil
+	m.mu.Lock()
+	j, err = m.restoreJob(rec)
+	m.mu.Unlock()
+	if err != nil {
+		t.Fatalf("restoreJob() error = %v", err)
+	}
+	if got := j.d.options.MaxRedirects; got != DefaultMaxRedirects {
+		t.Errorf("MaxRedirects = %d, want default %d", got, DefaultMaxRedirects)
+	}
+}
+
+// TestOpenManagerInvalidFile 测试队列文件损坏时返回错误
+func TestOpenManagerInvalidFile(t *testing.T) {
+	queuePath := filepath.Join(t.TempDir(), "queue.json")
+	if err := os.WriteFile(queuePath, []byte("{"), FilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenManager(queuePath); err == nil {
+		t.Error("OpenManager() should fail for corrupted queue file")
+	}
+}
+
+// TestJobStateText 测试任务状态的文本序列化
+func TestJobStateText(t *testing.T) {
+	for state := JobQueued; state <= JobCanceled; state++ {
+		text, err := state.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText() error = %v", err)
+		}
+		var got JobState
+		if err = got.UnmarshalText(text); err != nil || got != state {
+			t.Errorf("UnmarshalText(%s) = %v, %v, want %v", text, got, err, state)
+		}
+	}
+
+	var s JobState
+	if err := s.UnmarshalText([]byte("paused")); err == nil {
+		t.Error("UnmarshalText() should fail for unknown state")
+	}
+}
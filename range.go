@@ -0,0 +1,163 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rangeProbeTTL 主机需要直接探测的记录的有效期，过期后重新尝试HEAD请求
+	rangeProbeTTL = 10 * time.Minute
+	// rangeProbeMaxHosts 最多记录的主机数量，超过时淘汰最早的记录
+	rangeProbeMaxHosts = 64
+)
+
+// rangeProbeCache 记录HEAD请求无法判断Range支持情况的主机
+//
+// 这些主机之后的下载直接使用 Range: bytes=0-0 的GET请求探测，省去一次无用的HEAD请求。
+// 探测结果取决于下载器的客户端、代理和认证配置，因此每个下载器单独记录
+type rangeProbeCache struct {
+	mu    sync.Mutex
+	hosts map[string]time.Time // 主机 -> 记录时间
+}
+
+// has 判断主机是否需要直接探测，同时清理过期的记录
+func (c *rangeProbeCache) has(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	at, ok := c.hosts[host]
+	if ok && time.Since(at) > rangeProbeTTL {
+		delete(c.hosts, host)
+		return false
+	}
+	return ok
+}
+
+// add 记录主机，记录数达到上限时淘汰最早的一条
+func (c *rangeProbeCache) add(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hosts == nil {
+		c.hosts = make(map[string]time.Time)
+	}
+	if _, ok := c.hosts[host]; !ok && len(c.hosts) >= rangeProbeMaxHosts {
+		var oldest string
+		for h, at := range c.hosts {
+			if oldest == "" || at.Before(c.hosts[oldest]) {
+				oldest = h
+			}
+		}
+		delete(c.hosts, oldest)
+	}
+	c.hosts[host] = time.Now()
+}
+
+// remove 删除主机的记录
+func (c *rangeProbeCache) remove(host string) {
+	c.mu.Lock()
+	delete(c.hosts, host)
+	c.mu.Unlock()
+}
+
+// parseContentRange 解析 Content-Range 响应头，例如 "bytes 0-0/1234"
+// 文件总大小未知（"*"）时 total 为-1
+func parseContentRange(v string) (start, end, total int64, err error) {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(v), " ")
+	if !ok || unit != "bytes" {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+	}
+	rng, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+	}
+
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+		}
+	}
+
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start || (total >= 0 && end >= total) {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+	}
+	return start, end, total, nil
+}
+
+// headUseful 判断HEAD响应是否足以确定文件大小和Range支持情况
+//
+// 服务器拒绝HEAD请求、没有声明 Accept-Ranges 或未返回文件大小时，需要再用GET请求探测
+func headUseful(info *remoteInfo, acceptRanges string) bool {
+	if info.status != http.StatusOK || info.size < 0 {
+		return false
+	}
+	// 明确声明不支持Range请求时不再探测
+	return info.acceptRanges || strings.EqualFold(acceptRanges, "none")
+}
+
+// probeRange 发送 Range: bytes=0-0 的GET请求，根据响应判断是否支持分段下载
+func (d *Downloader) probeRange(ctx context.Context, rawURL string) (*remoteInfo, error) {
+	req, err := d.newRequest(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	d.setConditionHeaders(req)
+
+	resp, err := d.probeDo(req)
+	if err != nil {
+		return nil, err
+	}
+	// 不读取响应体直接关闭，服务器忽略Range时不会下载整个文件
+	defer resp.Body.Close()
+
+	info := &remoteInfo{
+		status:       resp.StatusCode,
+		size:         -1,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		filename:     nameFromDisposition(resp.Header.Get("Content-Disposition")),
+		finalURL:     resp.Request.URL.String(),
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		info.status = http.StatusOK
+		info.size = total
+		// 文件总大小未知时无法划分分片
+		info.acceptRanges = total > 0
+	case http.StatusOK:
+		info.size = resp.ContentLength
+	}
+	return info, nil
+}
+
+// probeDo 发送探测请求，设置了主机调度器时同样需要占用该主机的一个连接
+// 等待连接时使用请求的上下文，下载器停止时不再等待
+func (d *Downloader) probeDo(req *http.Request) (*http.Response, error) {
+	if g := d.options.HostGovernor; g != nil {
+		release, err := g.acquire(req.Context(), req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	return d.do(req)
+}
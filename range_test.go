@@ -0,0 +1,251 @@
+package dl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeTestServer 支持Range请求、但HEAD响应不可靠的测试服务器
+type rangeTestServer struct {
+	*httptest.Server
+	heads  atomic.Int32 // HEAD请求数
+	ranged atomic.Int32 // 下载分片的Range请求数（不含探测请求）
+}
+
+// newRangeTestServer 创建测试服务器，head 决定HEAD请求的响应方式：
+// "405" 拒绝HEAD请求，"no-header" 不返回 Accept-Ranges，"no-length" 不返回文件大小
+func newRangeTestServer(data []byte, head string) *rangeTestServer {
+	s := &rangeTestServer{}
+	size := int64(len(data))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			s.heads.Add(1)
+			switch head {
+			case "405":
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			case "no-header":
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			case "no-length":
+				w.Header().Set("Accept-Ranges", "bytes")
+			}
+			return
+		}
+
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			w.Write(data)
+			return
+		}
+		if rng != "bytes=0-0" {
+			s.ranged.Add(1)
+		}
+		var start, end int64
+		fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+	}))
+	return s
+}
+
+// TestRangeProbeFallback 测试HEAD响应无法判断时使用GET请求探测Range支持
+func TestRangeProbeFallback(t *testing.T) {
+	data := testData(48 * 1024)
+
+	for _, head := range []string{"405", "no-header", "no-length"} {
+		t.Run(head, func(t *testing.T) {
+			server := newRangeTestServer(data, head)
+			defer server.Close()
+
+			dir := t.TempDir()
+			target := filepath.Join(dir, "probed.bin")
+			newDownloader := func() *Downloader {
+				return newTestDownloader(t, server.URL,
+					WithFileName(target),
+					WithBaseDir(filepath.Join(dir, "cache")),
+					WithConcurrency(4),
+				)
+			}
+			download := func(d *Downloader) {
+				t.Helper()
+				if err := d.Start(); err != nil {
+					t.Fatalf("Start() error = %v", err)
+				}
+				got, err := os.ReadFile(target)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("downloaded file mismatch, err = %v", err)
+				}
+			}
+
+			d := newDownloader()
+			download(d)
+			if n := server.ranged.Load(); n != 4 {
+				t.Errorf("range requests = %d, want 4", n)
+			}
+
+			// 同一个下载器再次下载时直接使用GET探测，不再发送HEAD请求
+			download(d)
+			if n := server.heads.Load(); n != 1 {
+				t.Errorf("HEAD requests = %d, want 1", n)
+			}
+
+			// 其他下载器不共享探测记录
+			download(newDownloader())
+			if n := server.heads.Load(); n != 2 {
+				t.Errorf("HEAD requests = %d, want 2", n)
+			}
+		})
+	}
+}
+
+// TestRangeProbeCache 测试探测记录的过期和数量上限
+func TestRangeProbeCache(t *testing.T) {
+	var c rangeProbeCache
+	if c.has("a.example") {
+		t.Fatal("empty cache has a.example")
+	}
+
+	for i := 0; i < rangeProbeMaxHosts+10; i++ {
+		c.add(fmt.Sprintf("host%d.example", i))
+	}
+	if n := len(c.hosts); n != rangeProbeMaxHosts {
+		t.Errorf("cache holds %d hosts, want %d", n, rangeProbeMaxHosts)
+	}
+	if c.has("host0.example") || !c.has(fmt.Sprintf("host%d.example", rangeProbeMaxHosts+9)) {
+		t.Error("oldest hosts were not evicted first")
+	}
+
+	c.add("a.example")
+	c.hosts["a.example"] = time.Now().Add(-rangeProbeTTL - time.Second)
+	if c.has("a.example") {
+		t.Error("expired host is still cached")
+	}
+	if _, ok := c.hosts["a.example"]; ok {
+		t.Error("expired host was not removed")
+	}
+
+	c.add("b.example")
+	c.remove("b.example")
+	if c.has("b.example") {
+		t.Error("removed host is still cached")
+	}
+}
+
+// TestParseContentRange 测试解析 Content-Range 响应头
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int64
+		total      int64
+		wantErr    bool
+	}{
+		{"bytes 0-0/1234", 0, 0, 1234, false},
+		{"bytes 100-199/200", 100, 199, 200, false},
+		{"bytes 0-99/*", 0, 99, -1, false},
+		{"bytes 0-200/200", 0, 0, 0, true},
+		{"bytes 10-5/100", 0, 0, 0, true},
+		{"bytes */100", 0, 0, 0, true},
+		{"items 0-1/2", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		start, end, total, err := parseContentRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseContentRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (start != tt.start || end != tt.end || total != tt.total) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, want %d, %d, %d",
+				tt.value, start, end, total, tt.start, tt.end, tt.total)
+		}
+	}
+}
+
+// createRangeIgnoringTestServer 创建一个声明支持Range、实际却总是返回200和完整文件的测试服务器
+func createRangeIgnoringTestServer(data []byte) (*httptest.Server, *atomic.Int32) {
+	var gets atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		gets.Add(1)
+		w.Write(data)
+	}))
+	return server, &gets
+}
+
+// TestRangeIgnoredDowngrade 测试服务器忽略Range请求时改为单线程下载
+func TestRangeIgnoredDowngrade(t *testing.T) {
+	data := testData(64 * 1024)
+	server, gets := createRangeIgnoringTestServer(data)
+	defer server.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "ignored.bin")
+	d := newTestDownloader(t, server.URL,
+		WithFileName(target),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(4),
+	)
+
+	var total int64
+	d.OnProgress(func(loaded, t int64, rate string) {
+		total = loaded
+	})
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file mismatch (%d bytes), err = %v", len(got), err)
+	}
+	if total != int64(len(data)) {
+		t.Errorf("final progress = %d, want %d", total, len(data))
+	}
+	// 发现服务器忽略Range后不再分发剩余的分片
+	if n := gets.Load(); n > 5 {
+		t.Errorf("GET requests = %d, want at most 5", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache")); !os.IsNotExist(err) {
+		t.Errorf("cache directory was not removed, err = %v", err)
+	}
+}
+
+// TestRangeMismatch 测试206响应的 Content-Range 与请求不一致时报错
+func TestRangeMismatch(t *testing.T) {
+	data := testData(16 * 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+			return
+		}
+		// 总是返回开头的1KB
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-1023/%d", len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[:1024])
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := newTestDownloader(t, server.URL,
+		WithFileName(filepath.Join(dir, "mismatch.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(2),
+	)
+	if err := d.Start(); err == nil {
+		t.Error("Start() succeeded with mismatched Content-Range")
+	}
+}
@@ -0,0 +1,99 @@
+package dl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CrossHostPolicy 重定向到其他主机时的处理策略
+type CrossHostPolicy int
+
+const (
+	// CrossHostFollow 跟随重定向，但不向其他主机发送认证信息和Cookie请求头（默认，与标准库一致）
+	CrossHostFollow CrossHostPolicy = iota
+	// CrossHostKeepAuth 跟随重定向，并向其他主机发送认证信息，只应用于可信的CDN
+	CrossHostKeepAuth
+	// CrossHostDeny 拒绝重定向到其他主机，返回 ErrRedirectDenied
+	CrossHostDeny
+)
+
+// WithMaxRedirects 设置最多跟随的重定向次数，默认为 DefaultMaxRedirects，0表示不跟随重定向
+//
+// 自定义HTTP客户端设置了 CheckRedirect 时以客户端的设置为准
+func WithMaxRedirects(n int) OptionFunc {
+	return func(o *Options) {
+		o.MaxRedirects = n
+	}
+}
+
+// WithCrossHostRedirects 设置重定向到其他主机（不是原主机或其子域名）时的处理策略
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithAuth(auth), WithCrossHostRedirects(CrossHostDeny))
+func WithCrossHostRedirects(policy CrossHostPolicy) OptionFunc {
+	return func(o *Options) {
+		o.CrossHostRedirects = policy
+	}
+}
+
+// redirectPolicy 返回按配置检查重定向的 http.Client.CheckRedirect 函数
+func redirectPolicy(o *Options) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > o.MaxRedirects {
+			return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, o.MaxRedirects)
+		}
+		initial := via[0]
+		if sameSite(req.URL, initial.URL) {
+			return nil
+		}
+		switch o.CrossHostRedirects {
+		case CrossHostDeny:
+			return fmt.Errorf("%w: %s redirected to %s", ErrRedirectDenied, initial.URL.Host, req.URL.Host)
+		case CrossHostKeepAuth:
+			// 标准库在跳转到其他主机时会去掉 Authorization 请求头
+			if v := initial.Header.Get("Authorization"); v != "" && req.Header.Get("Authorization") == "" {
+				req.Header.Set("Authorization", v)
+			}
+		}
+		return nil
+	}
+}
+
+// sameSite 判断 dest 是否为 initial 的主机或其子域名，规则与标准库转发敏感请求头的规则一致
+func sameSite(dest, initial *url.URL) bool {
+	d, i := strings.ToLower(dest.Hostname()), strings.ToLower(initial.Hostname())
+	return d == i || strings.HasSuffix(d, "."+i)
+}
+
+// trusted 判断是否可以向该地址发送认证信息
+//
+// 分片请求直接发往探测时得到的最终地址，不经过重定向，因此需要自行按跨主机重定向的规则判断
+func (d *Downloader) trusted(u *url.URL) bool {
+	if d.options.CrossHostRedirects == CrossHostKeepAuth {
+		return true
+	}
+	for _, s := range d.sources {
+		if origin, err := url.Parse(s.url); err == nil && sameSite(u, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// target 返回分片请求使用的地址：探测时跟随重定向得到的最终地址，未探测时为原地址
+func (s *source) target() string {
+	if s.resolved != "" {
+		return s.resolved
+	}
+	return s.url
+}
+
+// pinSource 记录下载源跟随重定向之后的最终地址，本次下载的所有请求都直接使用该地址
+func (d *Downloader) pinSource(s *source, info *remoteInfo) {
+	d.srcMu.Lock()
+	defer d.srcMu.Unlock()
+	s.resolved = info.finalURL
+}
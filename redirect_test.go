@@ -0,0 +1,150 @@
+package dl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRedirectPinning 测试探测时解析重定向，所有分片请求都使用同一个最终地址
+func TestRedirectPinning(t *testing.T) {
+	data := testData(64 * 1024)
+	var origin, edge atomic.Int32
+	var wrongEdge atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		// 每次请求都重定向到不同的边缘节点
+		n := origin.Add(1)
+		http.Redirect(w, r, fmt.Sprintf("/edge/%d/file.bin?sig=x", n), http.StatusFound)
+	})
+	mux.HandleFunc("/edge/", func(w http.ResponseWriter, r *http.Request) {
+		edge.Add(1)
+		if !strings.HasPrefix(r.URL.Path, "/edge/1/") {
+			wrongEdge.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "pinned.bin")
+	d := newTestDownloader(t, server.URL+"/file.bin",
+		WithFileName(target),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithConcurrency(4),
+	)
+	var info StartInfo
+	d.OnDownloadStartInfo(func(i StartInfo) { info = i })
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file mismatch, err = %v", err)
+	}
+	if n := origin.Load(); n != 1 {
+		t.Errorf("origin requests = %d, want 1 (probe only)", n)
+	}
+	if n := wrongEdge.Load(); n != 0 {
+		t.Errorf("%d of %d edge requests were not pinned to the probed URL", n, edge.Load())
+	}
+	if want := server.URL + "/edge/1/file.bin?sig=x"; info.FinalURL != want || info.URL != server.URL+"/file.bin" {
+		t.Errorf("StartInfo = %+v, want FinalURL %s", info, want)
+	}
+	if !info.Segmented || info.Total != int64(len(data)) {
+		t.Errorf("StartInfo = %+v", info)
+	}
+}
+
+// TestRedirectPolicies 测试重定向次数上限和跨主机重定向策略
+func TestRedirectPolicies(t *testing.T) {
+	data := testData(32 * 1024)
+
+	// 模拟另一台主机上的CDN，记录收到的 Authorization 请求头
+	var cdnAuth atomic.Int32
+	var cdnRequests atomic.Int32
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cdnRequests.Add(1)
+		if r.Header.Get("Authorization") != "" {
+			cdnAuth.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer cdn.Close()
+	// 同一个测试服务器，以 localhost 访问时视为其他主机
+	cdnURL := strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1) + "/object"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(r.URL.Path, "/chain/%d", &n)
+		if n == 0 {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/chain/%d", n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/cdn", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, cdnURL, http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		opts     []OptionFunc
+		wantErr  error
+		wantAuth bool // CDN是否收到认证信息
+	}{
+		{"within limit", "/chain/3", []OptionFunc{WithMaxRedirects(3)}, nil, false},
+		{"too many", "/chain/3", []OptionFunc{WithMaxRedirects(2)}, ErrTooManyRedirects, false},
+		{"no redirects", "/chain/1", []OptionFunc{WithMaxRedirects(0)}, ErrTooManyRedirects, false},
+		{"cross host strips auth", "/cdn", nil, nil, false},
+		{"cross host keeps auth", "/cdn", []OptionFunc{WithCrossHostRedirects(CrossHostKeepAuth)}, nil, true},
+		{"cross host denied", "/cdn", []OptionFunc{WithCrossHostRedirects(CrossHostDeny)}, ErrRedirectDenied, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdnAuth.Store(0)
+			cdnRequests.Store(0)
+			dir := t.TempDir()
+			target := filepath.Join(dir, "redirect.bin")
+			opts := append([]OptionFunc{
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(3),
+				WithAuth(NewBearerAuth("secret")),
+			}, tt.opts...)
+
+			err := newTestDownloader(t, server.URL+tt.path, opts...).Start()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			if tt.path != "/cdn" {
+				return
+			}
+			if auth, total := cdnAuth.Load(), cdnRequests.Load(); (auth == total) != tt.wantAuth || (auth == 0) == tt.wantAuth {
+				t.Errorf("CDN received Authorization on %d of %d requests, want all: %v", auth, total, tt.wantAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// WithHeader 为下载器发出的所有请求添加请求头
+//
+// 可以多次调用，同名的请求头会保留所有的值。Range、If-Range 等下载所需的请求头由下载器设置，会覆盖这里的同名请求头
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithHeader("X-Api-Key", key), WithHeader("Accept", "application/octet-stream"))
+func WithHeader(key, value string) OptionFunc {
+	return func(o *Options) {
+		if o.Header == nil {
+			o.Header = make(http.Header)
+		}
+		o.Header.Add(key, value)
+	}
+}
+
+// WithUserAgent 设置所有请求的 User-Agent
+func WithUserAgent(ua string) OptionFunc {
+	return func(o *Options) {
+		if o.Header == nil {
+			o.Header = make(http.Header)
+		}
+		o.Header.Set("User-Agent", ua)
+	}
+}
+
+// WithRequestMutator 添加请求修改函数，HEAD请求、探测请求、分片请求和单线程下载请求发送之前都会调用
+//
+// 可以多次调用，按添加顺序在认证之后执行。修改函数看到的是即将发送的完整请求（包括 Range 和认证请求头），
+// 可用于签名或添加动态的令牌；返回错误时不发送请求，该错误会作为请求的错误返回。
+// 修改函数可能被多个协程同时调用
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithRequestMutator(func(req *http.Request) error {
+//	    req.Header.Set("X-Request-Time", time.Now().UTC().Format(time.RFC3339))
+//	    return nil
+//	}))
+func WithRequestMutator(f func(*http.Request) error) OptionFunc {
+	return func(o *Options) {
+		if f != nil {
+			o.RequestMutators = append(o.RequestMutators, f)
+		}
+	}
+}
+
+// newRequest 创建请求并加上自定义的请求头，下载器的所有请求都通过它创建
+func (d *Downloader) newRequest(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range d.options.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// do 设置认证信息、执行请求修改函数后发送请求，下载器的所有请求都通过它发送
+//
+// 服务器返回401且认证方式可以刷新凭据时，刷新凭据后重试一次
+func (d *Downloader) do(req *http.Request) (*http.Response, error) {
+	if err := d.prepare(req); err != nil {
+		return nil, err
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	r, ok := d.options.Auth.(Refresher)
+	if !ok {
+		return resp, nil
+	}
+
+	// 读完响应体以便复用连接
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if err = r.Refresh(req); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if err = d.prepare(retry); err != nil {
+		return nil, err
+	}
+	return d.httpClient.Do(retry)
+}
+
+// prepare 设置认证信息并依次执行请求修改函数
+func (d *Downloader) prepare(req *http.Request) error {
+	trusted := d.trusted(req.URL)
+	if !trusted {
+		// 与跟随重定向时一样，不向其他主机发送认证信息
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+	}
+	if a := d.options.Auth; a != nil && trusted {
+		if err := a.Authenticate(req); err != nil {
+			return fmt.Errorf("failed to authenticate request: %w", err)
+		}
+	}
+	for _, mutate := range d.options.RequestMutators {
+		if err := mutate(req); err != nil {
+			return fmt.Errorf("failed to prepare request: %w", err)
+		}
+	}
+	return nil
+}
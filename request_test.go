@@ -0,0 +1,122 @@
+package dl
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// headerTestServer 记录每个请求的请求头，head 为 "405" 时拒绝HEAD请求，ranges 为false时忽略Range请求
+func headerTestServer(data []byte, head string, ranges bool) (*httptest.Server, func() []http.Header) {
+	var mu sync.Mutex
+	var seen []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := r.Header.Clone()
+		h.Set("X-Method", r.Method)
+		mu.Lock()
+		seen = append(seen, h)
+		mu.Unlock()
+
+		if r.Method == http.MethodHead && head == "405" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	return server, func() []http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]http.Header(nil), seen...)
+	}
+}
+
+// TestRequestHeaders 测试自定义请求头、User-Agent和请求修改函数作用于所有请求
+func TestRequestHeaders(t *testing.T) {
+	data := testData(32 * 1024)
+
+	tests := []struct {
+		name   string
+		head   string
+		ranges bool
+	}{
+		{"segmented", "", true},
+		{"range probe", "405", true},
+		{"single stream", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, seen := headerTestServer(data, tt.head, tt.ranges)
+			defer server.Close()
+
+			dir := t.TempDir()
+			target := filepath.Join(dir, "headers.bin")
+			d := newTestDownloader(t, server.URL,
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(3),
+				WithHeader("X-Token", "secret"),
+				WithHeader("X-Token", "second"),
+				WithUserAgent("corp-agent/1.0"),
+				WithRequestMutator(func(req *http.Request) error {
+					// 修改函数能看到下载器设置的请求头
+					req.Header.Set("X-Signed", req.Method+"|"+req.Header.Get("Range"))
+					return nil
+				}),
+			)
+			if err := d.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+
+			requests := seen()
+			if len(requests) < 2 {
+				t.Fatalf("server saw %d requests, want at least 2", len(requests))
+			}
+			for _, h := range requests {
+				method := h.Get("X-Method")
+				if v := h.Values("X-Token"); len(v) != 2 || v[0] != "secret" || v[1] != "second" {
+					t.Errorf("%s request X-Token = %v", method, v)
+				}
+				if ua := h.Get("User-Agent"); ua != "corp-agent/1.0" {
+					t.Errorf("%s request User-Agent = %q", method, ua)
+				}
+				if want := method + "|" + h.Get("Range"); h.Get("X-Signed") != want {
+					t.Errorf("%s request X-Signed = %q, want %q", method, h.Get("X-Signed"), want)
+				}
+			}
+		})
+	}
+}
+
+// TestRequestMutatorError 测试请求修改函数返回错误时不发送请求
+func TestRequestMutatorError(t *testing.T) {
+	server, seen := headerTestServer(testData(1024), "", true)
+	defer server.Close()
+
+	errDenied := errors.New("denied")
+	dir := t.TempDir()
+	d := newTestDownloader(t, server.URL,
+		WithFileName(filepath.Join(dir, "denied.bin")),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithRequestMutator(func(*http.Request) error { return errDenied }),
+	)
+	if err := d.Start(); !errors.Is(err, errDenied) {
+		t.Errorf("Start() error = %v, want %v", err, errDenied)
+	}
+	if n := len(seen()); n != 0 {
+		t.Errorf("server saw %d requests, want 0", n)
+	}
+}
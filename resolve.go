@@ -0,0 +1,377 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dohTimeout 单个DoH请求的超时时间
+	dohTimeout = 10 * time.Second
+	// dnsMaxMessageSize DNS消息的最大长度
+	dnsMaxMessageSize = 65535
+)
+
+// WithResolve 将主机名和端口固定解析到指定的IP地址，与 curl --resolve 相同
+//
+// 只改变建立连接的地址，请求的Host头和TLS的SNI、证书验证仍然使用URL中的主机名。
+// 有多个地址时依次尝试，直到连接成功。使用代理时作用于代理服务器的地址
+//
+// 参数:
+//
+//	hostPort - 主机名和端口，例如 "files.example.com:443"
+//	addrs - IP地址，例如 "203.0.113.10" 或 "2001:db8::10"
+//
+// 示例:
+//
+//	// 绕过DNS直接测试某个镜像节点
+//	dl, err := NewDownloader("https://files.example.com/a.iso",
+//	    WithResolve("files.example.com:443", "203.0.113.10"))
+func WithResolve(hostPort string, addrs ...string) OptionFunc {
+	return func(o *Options) {
+		key, err := parseHostMapping(hostPort, false)
+		if err != nil {
+			o.errs = append(o.errs, err)
+			return
+		}
+		if len(addrs) == 0 {
+			o.errs = append(o.errs, fmt.Errorf("%w: no addresses for %s", ErrInvalidResolve, hostPort))
+			return
+		}
+		ips := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+			if ip == nil {
+				o.errs = append(o.errs, fmt.Errorf("%w: invalid IP address %q", ErrInvalidResolve, addr))
+				return
+			}
+			ips = append(ips, ip.String())
+		}
+		if o.Resolve == nil {
+			o.Resolve = make(map[string][]string)
+		}
+		o.Resolve[key] = ips
+	}
+}
+
+// WithConnectTo 将连接 from 的请求改为连接 to，与 curl --connect-to 相同
+//
+// 主机名或端口为空表示匹配任意值（from）或保持不变（to），例如 "files.example.com:" 匹配该主机的所有端口。
+// 与 WithResolve 一样只改变建立连接的地址，Host头和SNI不变；改写后的地址仍然按 WithResolve 和 WithResolver 解析
+//
+// 参数:
+//
+//	from - 要改写的主机名和端口，例如 "files.example.com:443"
+//	to - 实际连接的主机名和端口，例如 "mirror-3.example.net:8443"
+func WithConnectTo(from, to string) OptionFunc {
+	return func(o *Options) {
+		key, err := parseHostMapping(from, true)
+		if err != nil {
+			o.errs = append(o.errs, err)
+			return
+		}
+		if _, err = parseHostMapping(to, true); err != nil {
+			o.errs = append(o.errs, err)
+			return
+		}
+		if o.ConnectTo == nil {
+			o.ConnectTo = make(map[string]string)
+		}
+		o.ConnectTo[key] = to
+	}
+}
+
+// WithResolver 使用自定义的DNS解析器解析主机名
+func WithResolver(r *net.Resolver) OptionFunc {
+	return func(o *Options) {
+		o.Resolver = r
+	}
+}
+
+// WithDNSServer 使用指定的DNS服务器解析主机名，代替系统配置的服务器
+//
+// 参数:
+//
+//	server - DNS服务器地址，没有端口时使用53，例如 "1.1.1.1" 或 "[2606:4700::1111]:53"
+func WithDNSServer(server string) OptionFunc {
+	return func(o *Options) {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), "53")
+		}
+		if _, err := parseHostMapping(server, false); err != nil {
+			o.errs = append(o.errs, err)
+			return
+		}
+		dialer := &net.Dialer{}
+		o.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+}
+
+// WithDoH 使用DNS over HTTPS（RFC 8484）服务解析主机名
+// DoH请求不经过下载器的代理和TLS配置；服务地址使用域名时，该域名本身通过系统DNS解析
+//
+// 参数:
+//
+//	endpoint - DoH服务地址，例如 "https://1.1.1.1/dns-query"
+func WithDoH(endpoint string) OptionFunc {
+	return func(o *Options) {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			o.errs = append(o.errs, fmt.Errorf("%w: invalid DoH endpoint %q", ErrInvalidResolve, endpoint))
+			return
+		}
+		client := &http.Client{Timeout: dohTimeout}
+		o.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return &dohConn{endpoint: endpoint, client: client}, nil
+			},
+		}
+	}
+}
+
+// parseHostMapping 校验 "host:port" 形式的地址，返回用于匹配的小写键；allowEmpty 表示主机名和端口可以为空
+func parseHostMapping(hostPort string, allowEmpty bool) (string, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidResolve, err)
+	}
+	if host == "" && !allowEmpty {
+		return "", fmt.Errorf("%w: missing host in %q", ErrInvalidResolve, hostPort)
+	}
+	if port != "" || !allowEmpty {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return "", fmt.Errorf("%w: invalid port in %q", ErrInvalidResolve, hostPort)
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(host), port), nil
+}
+
+// customResolve 判断是否设置了需要改写连接地址的配置
+func (o *Options) customResolve() bool {
+	return len(o.Resolve) > 0 || len(o.ConnectTo) > 0 || o.Resolver != nil
+}
+
+// resolveDial 在拨号前按 ConnectTo、Resolve 和 Resolver 确定实际连接的地址，dial 为nil时使用默认拨号器
+func (o *Options) resolveDial(dial dialFunc) dialFunc {
+	if dial == nil {
+		dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+	}
+	resolve, connectTo, resolver := o.Resolve, o.ConnectTo, o.Resolver
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		host, port = connectToTarget(connectTo, host, port)
+
+		ips, ok := resolve[net.JoinHostPort(strings.ToLower(host), port)]
+		if !ok {
+			if resolver == nil || net.ParseIP(host) != nil {
+				return dial(ctx, network, net.JoinHostPort(host, port))
+			}
+			if ips, err = resolver.LookupHost(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+
+		// 与 net.Dialer 一样依次尝试每个地址，返回第一个错误
+		var firstErr error
+		for _, ip := range ips {
+			if !ipMatchesNetwork(ip, network) {
+				continue
+			}
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if firstErr == nil {
+			firstErr = &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+		}
+		return nil, firstErr
+	}
+}
+
+// connectToTarget 按 WithConnectTo 的规则改写主机名和端口，依次匹配主机名和端口、主机名、端口和任意地址
+func connectToTarget(rules map[string]string, host, port string) (string, string) {
+	if len(rules) == 0 {
+		return host, port
+	}
+	h := strings.ToLower(host)
+	for _, key := range []string{net.JoinHostPort(h, port), net.JoinHostPort(h, ""), net.JoinHostPort("", port), ":"} {
+		to, ok := rules[key]
+		if !ok {
+			continue
+		}
+		toHost, toPort, _ := net.SplitHostPort(to)
+		if toHost != "" {
+			host = toHost
+		}
+		if toPort != "" {
+			port = toPort
+		}
+		break
+	}
+	return host, port
+}
+
+// ipMatchesNetwork 判断IP地址是否可以用于 tcp4、tcp6 等指定了地址族的网络
+func ipMatchesNetwork(ip, network string) bool {
+	isV4 := net.ParseIP(ip).To4() != nil
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return isV4
+	case strings.HasSuffix(network, "6"):
+		return !isV4
+	}
+	return true
+}
+
+// dohConn 将Go解析器通过TCP发送的DNS消息转换为DoH请求
+//
+// 解析器对不是 net.PacketConn 的连接使用TCP格式，每条消息前有两字节的长度
+type dohConn struct {
+	endpoint string
+	client   *http.Client
+
+	mu       sync.Mutex
+	deadline time.Time
+	wbuf     bytes.Buffer // 还不完整的查询
+	rbuf     bytes.Buffer // 等待读取的响应
+}
+
+// Write 收到完整的查询后发送DoH请求，响应放入读取缓冲区
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wbuf.Write(b)
+	for c.wbuf.Len() >= 2 {
+		n := int(binary.BigEndian.Uint16(c.wbuf.Bytes()))
+		if c.wbuf.Len() < 2+n {
+			break
+		}
+		c.wbuf.Next(2)
+		resp, err := c.exchange(bytes.Clone(c.wbuf.Next(n)))
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+		c.rbuf.Write(resp)
+	}
+	return len(b), nil
+}
+
+// Read 读取DoH响应
+func (c *dohConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(b)
+}
+
+// exchange 通过HTTP POST发送一条DNS查询
+func (c *dohConn) exchange(msg []byte) ([]byte, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("doh: invalid DNS query")
+	}
+	// RFC 8484 建议查询ID为0以便缓存，响应中恢复原来的ID供解析器校验
+	id := binary.BigEndian.Uint16(msg)
+	binary.BigEndian.PutUint16(msg, 0)
+
+	ctx := context.Background()
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dnsMaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 12 || len(body) > dnsMaxMessageSize {
+		return nil, errors.New("doh: invalid DNS response")
+	}
+	binary.BigEndian.PutUint16(body, id)
+	return body, nil
+}
+
+// Close 实现 net.Conn 接口
+func (c *dohConn) Close() error {
+	return nil
+}
+
+// LocalAddr 实现 net.Conn 接口
+func (c *dohConn) LocalAddr() net.Addr {
+	return dohAddr{}
+}
+
+// RemoteAddr 实现 net.Conn 接口
+func (c *dohConn) RemoteAddr() net.Addr {
+	return dohAddr{}
+}
+
+// SetDeadline 设置DoH请求的截止时间
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+// SetReadDeadline 实现 net.Conn 接口，查询在写入时完成，读取不会阻塞
+func (c *dohConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline 设置DoH请求的截止时间
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// dohAddr DoH连接的地址
+type dohAddr struct{}
+
+// Network 实现 net.Addr 接口
+func (dohAddr) Network() string { return "doh" }
+
+// String 实现 net.Addr 接口
+func (dohAddr) String() string { return "doh" }
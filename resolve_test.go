@@ -0,0 +1,284 @@
+package dl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dnsResponse 为DNS查询构造响应：records 中的域名返回A记录，其他域名返回NXDOMAIN
+func dnsResponse(query []byte, records map[string]net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// 读取问题部分的域名、类型和类别
+	var labels []string
+	p := 12
+	for p < len(query) && query[p] != 0 {
+		n := int(query[p])
+		if p+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[p+1:p+1+n]))
+		p += 1 + n
+	}
+	if p+5 > len(query) {
+		return nil
+	}
+	question := query[12 : p+5]
+	qtype := binary.BigEndian.Uint16(query[p+1:])
+	ip, found := records[strings.ToLower(strings.Join(labels, "."))]
+
+	resp := append([]byte{}, query[:2]...)
+	flags := uint16(0x8180) // QR、RD、RA
+	if !found {
+		flags |= 3 // NXDOMAIN
+	}
+	var answers uint16
+	if found && qtype == 1 {
+		answers = 1
+	}
+	resp = binary.BigEndian.AppendUint16(resp, flags)
+	resp = binary.BigEndian.AppendUint16(resp, 1)
+	resp = binary.BigEndian.AppendUint16(resp, answers)
+	resp = append(resp, 0, 0, 0, 0)
+	resp = append(resp, question...)
+	if answers > 0 {
+		// 名称指向问题部分，类型A，类别IN，TTL 60秒
+		resp = append(resp, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+		resp = append(resp, ip.To4()...)
+	}
+	return resp
+}
+
+// startDNSServer 启动只支持UDP的DNS服务器，返回地址和收到的查询数
+func startDNSServer(t *testing.T, records map[string]net.IP) (string, *atomic.Int32) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, dnsMaxMessageSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			if resp := dnsResponse(buf[:n], records); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String(), &queries
+}
+
+// createDoHServer 创建DoH（RFC 8484 POST）服务器，返回收到的查询数
+func createDoHServer(t *testing.T, records map[string]net.IP) (*httptest.Server, *atomic.Int32) {
+	var queries atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		if len(query) < 2 || query[0] != 0 || query[1] != 0 {
+			w.WriteHeader(http.StatusBadRequest) // RFC 8484 建议查询ID为0
+			return
+		}
+		queries.Add(1)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsResponse(query, records))
+	}))
+	t.Cleanup(server.Close)
+	return server, &queries
+}
+
+// TestResolve 测试地址映射、连接改写和自定义DNS解析只改变连接地址，不改变Host头
+func TestResolve(t *testing.T) {
+	data := testData(128 * 1024)
+	var mu sync.Mutex
+	hosts := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts[r.Host] = true
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	// 目标域名无法通过系统DNS解析，只有映射生效时才能下载成功
+	records := map[string]net.IP{"files.example.invalid": net.IPv4(127, 0, 0, 1)}
+	dnsAddr, dnsQueries := startDNSServer(t, records)
+	doh, dohQueries := createDoHServer(t, records)
+	target := "files.example.invalid:" + port
+
+	tests := []struct {
+		name    string
+		host    string // URL中的主机名和端口
+		opts    []OptionFunc
+		queries *atomic.Int32 // 应当收到查询的DNS服务器
+		wantErr bool
+	}{
+		{"resolve", target, []OptionFunc{WithResolve(target, "127.0.0.1")}, nil, false},
+		{"resolve case insensitive", "Files.Example.Invalid:" + port, []OptionFunc{WithResolve(target, "127.0.0.1")}, nil, false},
+		{"resolve fallback", target, []OptionFunc{WithResolve(target, "127.0.0.2", "127.0.0.1")}, nil, false},
+		{"resolve other port", "files.example.invalid:1", []OptionFunc{WithResolve(target, "127.0.0.1")}, nil, true},
+		{"connect to", "files.example.invalid", []OptionFunc{WithConnectTo("files.example.invalid:80", "127.0.0.1:"+port)}, nil, false},
+		{"connect to then resolve", "files.example.invalid:8080", []OptionFunc{
+			WithConnectTo("files.example.invalid:", "mirror.example.invalid:"+port),
+			WithResolve("mirror.example.invalid:"+port, "127.0.0.1"),
+		}, nil, false},
+		{"DNS server", target, []OptionFunc{WithDNSServer(dnsAddr)}, dnsQueries, false},
+		{"DoH", target, []OptionFunc{WithDoH(doh.URL + "/dns-query")}, dohQueries, false},
+		{"DoH unknown host", "missing.example.invalid:" + port, []OptionFunc{WithDoH(doh.URL + "/dns-query")}, dohQueries, true},
+		{"resolve overrides resolver", target, []OptionFunc{WithDoH(doh.URL), WithResolve(target, "127.0.0.1")}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			clear(hosts)
+			mu.Unlock()
+			dnsQueries.Store(0)
+			dohQueries.Store(0)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "resolved.bin")
+			opts := append([]OptionFunc{
+				WithFileName(path),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+				WithConnectTimeout(5 * time.Second),
+			}, tt.opts...)
+
+			err := newTestDownloader(t, "http://"+tt.host+"/file.bin", opts...).Start()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.queries != nil && tt.queries.Load() == 0 {
+				t.Error("the configured DNS server received no queries")
+			}
+			if tt.queries == nil && dnsQueries.Load()+dohQueries.Load() > 0 {
+				t.Error("custom resolver was queried for a mapped address")
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(hosts) != 1 || !hosts[tt.host] {
+				t.Errorf("Host headers = %v, want only %s", hosts, tt.host)
+			}
+		})
+	}
+}
+
+// TestResolveTLS 测试映射地址后TLS仍然使用URL中的主机名发送SNI和验证证书
+func TestResolveTLS(t *testing.T) {
+	data := testData(64 * 1024)
+	caPEM, _, serverCert := createTestCA(t)
+	var serverName atomic.Value
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName.Store(r.TLS.ServerName)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	server.StartTLS()
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secure.bin")
+	d := newTestDownloader(t, "https://files.example.test:"+port+"/secure.bin",
+		WithFileName(path),
+		WithBaseDir(filepath.Join(dir, "cache")),
+		WithCACerts(caPEM),
+		WithResolve("files.example.test:"+port, "127.0.0.1"),
+	)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+		t.Fatal("downloaded file mismatch")
+	}
+	if got := serverName.Load(); got != "files.example.test" {
+		t.Errorf("SNI = %v, want files.example.test", got)
+	}
+}
+
+// TestConnectToTarget 测试连接改写规则的匹配顺序
+func TestConnectToTarget(t *testing.T) {
+	rules := map[string]string{
+		"a.example:443": "x.example:1",
+		"a.example:":    "y.example:",
+		":80":           ":8080",
+		"[::1]:":        "127.0.0.1:",
+		":":             "z.example:",
+	}
+
+	tests := []struct {
+		host, port         string
+		wantHost, wantPort string
+	}{
+		{"a.example", "443", "x.example", "1"},
+		{"A.Example", "8443", "y.example", "8443"},
+		{"b.example", "80", "b.example", "8080"},
+		{"::1", "22", "127.0.0.1", "22"},
+		{"b.example", "22", "z.example", "22"},
+	}
+
+	for _, tt := range tests {
+		host, port := connectToTarget(rules, tt.host, tt.port)
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("connectToTarget(%s, %s) = %s:%s, want %s:%s", tt.host, tt.port, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
+
+// TestResolveOptionErrors 测试无效的地址映射和DNS配置在 NewDownloader 时报告
+func TestResolveOptionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  OptionFunc
+	}{
+		{"resolve without port", WithResolve("files.example.com", "127.0.0.1")},
+		{"resolve without host", WithResolve(":443", "127.0.0.1")},
+		{"resolve invalid port", WithResolve("files.example.com:https", "127.0.0.1")},
+		{"resolve without address", WithResolve("files.example.com:443")},
+		{"resolve invalid address", WithResolve("files.example.com:443", "files.example.net")},
+		{"connect to without colon", WithConnectTo("files.example.com", "127.0.0.1:8080")},
+		{"connect to invalid target", WithConnectTo("files.example.com:", "127.0.0.1:99999")},
+		{"empty DNS server", WithDNSServer("")},
+		{"DoH invalid scheme", WithDoH("ftp://dns.example.com/dns-query")},
+		{"DoH without host", WithDoH("https:///dns-query")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDownloader("https://files.example.com/file.bin", tt.opt)
+			if !errors.Is(err, ErrInvalidResolve) {
+				t.Errorf("NewDownloader() error = %v, want ErrInvalidResolve", err)
+			}
+		})
+	}
+}
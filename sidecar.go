@@ -0,0 +1,66 @@
+package dl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// MetaSuffix 记录已完成下载的远程文件信息的附属文件后缀
+const MetaSuffix = ".dlmeta"
+
+// fileMeta 附属文件的内容
+type fileMeta struct {
+	URL          string `json:"url"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// metaPath 返回目标文件的附属文件路径
+func (d *Downloader) metaPath() string {
+	return d.options.FilePath + MetaSuffix
+}
+
+// loadMeta 读取目标文件的附属文件，不存在或无法解析时返回nil
+func (d *Downloader) loadMeta() *fileMeta {
+	data, err := os.ReadFile(d.metaPath())
+	if err != nil {
+		return nil
+	}
+	var m fileMeta
+	if json.Unmarshal(data, &m) != nil {
+		return nil
+	}
+	return &m
+}
+
+// updateMeta 下载完成后记录远程文件信息
+//
+// 只在需要比较远程文件的策略或条件下载时写入；已有附属文件时总是更新，避免留下过期的记录
+func (d *Downloader) updateMeta() error {
+	path := d.metaPath()
+	if d.options.ExistingFile != ExistingSkipIfSame && !d.options.Conditional {
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+
+	fi, err := os.Stat(d.options.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded file: %w", err)
+	}
+	m := fileMeta{URL: d.url, Size: fi.Size()}
+	if d.remote != nil {
+		m.ETag, m.LastModified = d.remote.etag, d.remote.lastModified
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, data, FilePerm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package dl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errSpaceUnsupported 当前平台无法查询可用空间
+var errSpaceUnsupported = errors.New("free space query is not supported")
+
+// spaceOf 查询路径所在文件系统的标识和可用空间，测试时可以替换
+var spaceOf = volumeSpace
+
+// spaceNeed 需要在某个目录所在文件系统上占用的空间
+type spaceNeed struct {
+	dir   string
+	bytes int64
+}
+
+// checkSpace 在下载前检查各目录所在文件系统的可用空间是否足够
+//
+// 位于同一文件系统的需求会累加；无法查询可用空间时跳过检查
+func (d *Downloader) checkSpace(needs ...spaceNeed) error {
+	type volumeNeed struct {
+		dir   string
+		bytes uint64
+		free  uint64
+	}
+	volumes := make(map[string]*volumeNeed)
+	for _, n := range needs {
+		if n.bytes <= 0 {
+			continue
+		}
+		vol, free, err := spaceOf(existingDir(n.dir))
+		if err != nil {
+			return nil
+		}
+		v, ok := volumes[vol]
+		if !ok {
+			v = &volumeNeed{dir: n.dir, free: free}
+			volumes[vol] = v
+		}
+		v.bytes += uint64(n.bytes)
+	}
+
+	for _, v := range volumes {
+		if v.bytes > v.free {
+			return fmt.Errorf("%w: need %d bytes in %s, %d available", ErrInsufficientSpace, v.bytes, v.dir, v.free)
+		}
+	}
+	return nil
+}
+
+// existingDir 返回path自身或最近的已存在的上级目录
+func existingDir(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// dirSize 返回目录中所有文件的总大小，目录不存在时返回0
+func dirSize(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total
+}
@@ -0,0 +1,59 @@
+package dl
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestPreflightChecks 测试开始下载前的大小上限和磁盘空间检查
+func TestPreflightChecks(t *testing.T) {
+	const size = 64 * 1024
+	data := testData(size)
+
+	mirror := newMirrorServer(data, "", -1, 0)
+	defer mirror.Close()
+	single := createTestServer(size, false)
+	defer single.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		free    uint64
+		maxSize int64
+		wantErr error
+	}{
+		// 分段下载合并时需要两倍的空间
+		{"segmented needs double", mirror.URL, size * 3 / 2, 0, ErrInsufficientSpace},
+		{"segmented fits", mirror.URL, size * 2, 0, nil},
+		{"single fits", single.URL, size * 3 / 2, 0, nil},
+		{"single too small", single.URL, size / 2, 0, ErrInsufficientSpace},
+		{"advertised size over limit", mirror.URL, size * 2, size - 1, ErrMaxSizeExceeded},
+	}
+
+	orig := spaceOf
+	defer func() { spaceOf = orig }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spaceOf = func(path string) (string, uint64, error) {
+				return "test-volume", tt.free, nil
+			}
+			mirror.served.Store(0)
+
+			dir := t.TempDir()
+			d := newTestDownloader(t, tt.url,
+				WithFileName(filepath.Join(dir, "preflight.bin")),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(2),
+				WithMaxSize(tt.maxSize),
+			)
+			if err := d.Start(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && tt.url == mirror.URL && mirror.served.Load() != 0 {
+				t.Errorf("served %d bytes before the preflight check failed", mirror.served.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package dl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// WithCACertFile 信任PEM文件中的CA证书，用于使用私有CA签发证书的服务器
+//
+// 证书添加在系统根证书之上，可以多次调用添加多个文件；文件无法读取或不包含证书时 NewDownloader 返回 ErrInvalidTLSOption
+//
+// 参数:
+//
+//	path - PEM格式的CA证书文件，可以包含多个证书
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url, WithCACertFile("/etc/pki/corp-ca.pem"))
+func WithCACertFile(path string) OptionFunc {
+	return func(o *Options) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			o.errs = append(o.errs, fmt.Errorf("%w: failed to read CA file: %v", ErrInvalidTLSOption, err))
+			return
+		}
+		o.addCACerts(data, path)
+	}
+}
+
+// WithCACerts 信任PEM格式的CA证书，与 WithCACertFile 相同，证书直接以字节形式提供
+func WithCACerts(pemCerts []byte) OptionFunc {
+	return func(o *Options) {
+		o.addCACerts(pemCerts, "PEM data")
+	}
+}
+
+// addCACerts 将PEM证书加入根证书池，第一次调用时以系统根证书为基础
+func (o *Options) addCACerts(pemCerts []byte, source string) {
+	if o.RootCAs == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		o.RootCAs = pool
+	}
+	if !o.RootCAs.AppendCertsFromPEM(pemCerts) {
+		o.errs = append(o.errs, fmt.Errorf("%w: no certificates found in %s", ErrInvalidTLSOption, source))
+	}
+}
+
+// WithClientCert 设置双向TLS认证使用的客户端证书
+//
+// 参数:
+//
+//	certFile - PEM格式的证书文件，可以在证书之后附带中间证书
+//	keyFile - PEM格式的私钥文件
+//
+// 示例:
+//
+//	dl, err := NewDownloader(url,
+//	    WithCACertFile("corp-ca.pem"),
+//	    WithClientCert("client.crt", "client.key"),
+//	)
+func WithClientCert(certFile, keyFile string) OptionFunc {
+	return func(o *Options) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			o.errs = append(o.errs, fmt.Errorf("%w: failed to load client certificate: %v", ErrInvalidTLSOption, err))
+			return
+		}
+		o.ClientCerts = append(o.ClientCerts, cert)
+	}
+}
+
+// WithClientCertPKCS12 从PKCS#12文件（.p12/.pfx）读取双向TLS认证使用的客户端证书和私钥
+//
+// 支持 OpenSSL（包括 -legacy 格式）和 Windows 导出的使用密码保护的文件，文件中的其他证书作为证书链发送
+//
+// 参数:
+//
+//	path - PKCS#12文件路径
+//	password - 文件密码
+func WithClientCertPKCS12(path, password string) OptionFunc {
+	return func(o *Options) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			o.errs = append(o.errs, fmt.Errorf("%w: failed to read PKCS#12 file: %v", ErrInvalidTLSOption, err))
+			return
+		}
+		cert, err := loadPKCS12(data, password)
+		if err != nil {
+			o.errs = append(o.errs, fmt.Errorf("%w: failed to load %s: %v", ErrInvalidTLSOption, path, err))
+			return
+		}
+		o.ClientCerts = append(o.ClientCerts, cert)
+	}
+}
+
+// loadPKCS12 解析PKCS#12文件中的私钥和证书，私钥对应的证书排在第一个，其余证书作为证书链
+func loadPKCS12(data []byte, password string) (tls.Certificate, error) {
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{PrivateKey: key, Leaf: leaf, Certificate: [][]byte{leaf.Raw}}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+// WithMinTLSVersion 设置最低TLS版本，例如 tls.VersionTLS12 或 tls.VersionTLS13
+func WithMinTLSVersion(version uint16) OptionFunc {
+	return func(o *Options) {
+		if version < tls.VersionTLS10 || version > tls.VersionTLS13 {
+			o.errs = append(o.errs, fmt.Errorf("%w: unknown TLS version 0x%04x", ErrInvalidTLSOption, version))
+			return
+		}
+		o.MinTLSVersion = version
+	}
+}
+
+// WithPinnedPublicKeys 固定服务器证书链中的公钥，只有证书链中包含匹配的公钥时才允许连接
+//
+// 公钥使用与 curl --pinnedpubkey 相同的格式，即 SubjectPublicKeyInfo 的SHA-256哈希的Base64编码，
+// 可以带 "sha256//" 前缀，多个公钥可以用 ";" 分隔。固定公钥在正常的证书验证之外进行，
+// 不匹配时下载失败并返回 ErrPinMismatch。可以用 PublicKeyPin 计算证书的公钥哈希
+//
+// 参数:
+//
+//	pins - 允许的公钥哈希，例如 "sha256//YhKJKSzoTt2b5FP18fvpHo7fJYqQCjAa3HWY3tvRMwE="
+func WithPinnedPublicKeys(pins ...string) OptionFunc {
+	return func(o *Options) {
+		for _, pin := range pins {
+			for _, p := range strings.Split(pin, ";") {
+				p = strings.TrimPrefix(strings.TrimSpace(p), "sha256//")
+				if p == "" {
+					continue
+				}
+				sum, err := base64.StdEncoding.DecodeString(p)
+				if err != nil || len(sum) != sha256.Size {
+					o.errs = append(o.errs, fmt.Errorf("%w: invalid public key pin %q", ErrInvalidTLSOption, p))
+					continue
+				}
+				o.PinnedPublicKeys = append(o.PinnedPublicKeys, sum)
+			}
+		}
+	}
+}
+
+// PublicKeyPin 返回证书公钥的哈希，格式为 "sha256//" 加上Base64编码，可以传给 WithPinnedPublicKeys
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// customTLS 判断是否设置了需要修改TLS配置的选项
+func (o *Options) customTLS() bool {
+	return o.TLSConfig != nil || o.RootCAs != nil || len(o.ClientCerts) > 0 ||
+		o.MinTLSVersion != 0 || len(o.PinnedPublicKeys) > 0
+}
+
+// tlsConfig 在 base（WithTLSConfig 或客户端原有的TLS配置）的副本上应用根证书、客户端证书、最低版本和公钥固定
+func (o *Options) tlsConfig(base *tls.Config) *tls.Config {
+	cfg := &tls.Config{}
+	if o.TLSConfig != nil {
+		cfg = o.TLSConfig.Clone()
+	} else if base != nil {
+		cfg = base.Clone()
+	}
+	if o.RootCAs != nil {
+		cfg.RootCAs = o.RootCAs
+	}
+	if len(o.ClientCerts) > 0 {
+		// Clone 复制的切片与原配置共享底层数组，追加前先截断容量
+		cfg.Certificates = append(slices.Clip(cfg.Certificates), o.ClientCerts...)
+	}
+	if o.MinTLSVersion != 0 {
+		cfg.MinVersion = o.MinTLSVersion
+	}
+	if len(o.PinnedPublicKeys) > 0 {
+		cfg.VerifyConnection = verifyPinnedKeys(cfg.VerifyConnection, o.PinnedPublicKeys)
+	}
+	return cfg
+}
+
+// verifyPinnedKeys 返回检查服务器公钥的 VerifyConnection 函数，next 为原有的检查函数
+//
+// 证书链经过验证时，链中任意证书（包括中间证书和根证书）的公钥匹配即可；
+// 跳过证书验证（InsecureSkipVerify）时只有叶子证书经过握手签名验证，只检查叶子证书
+func verifyPinnedKeys(next func(tls.ConnectionState) error, pins [][]byte) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if next != nil {
+			if err := next(cs); err != nil {
+				return err
+			}
+		}
+		certs := cs.PeerCertificates[:min(1, len(cs.PeerCertificates))]
+		if len(cs.VerifiedChains) > 0 {
+			certs = nil
+			for _, chain := range cs.VerifiedChains {
+				certs = append(certs, chain...)
+			}
+		}
+		for _, cert := range certs {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrPinMismatch, cs.ServerName)
+	}
+}
@@ -0,0 +1,243 @@
+package dl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testCertPassword testdata 中PKCS#12文件的密码
+const testCertPassword = "s3cret"
+
+// errAny 表示期望任意错误
+var errAny = errors.New("any error")
+
+// createTestCA 生成测试用的CA和由它签发的 127.0.0.1 和 files.example.test 服务器证书
+func createTestCA(t *testing.T) (caPEM []byte, ca *x509.Certificate, server tls.Certificate) {
+	t.Helper()
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		return key
+	}
+	create := func(tmpl, parent *x509.Certificate, pub crypto.PublicKey, priv crypto.Signer) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+		if err != nil {
+			t.Fatalf("CreateCertificate() error = %v", err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+
+	caKey := newKey()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dl test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca = create(caTmpl, caTmpl, caKey.Public(), caKey)
+
+	serverKey := newKey()
+	leaf := create(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"files.example.test"},
+	}, ca, serverKey.Public(), caKey)
+
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	server = tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: serverKey, Leaf: leaf}
+	return caPEM, ca, server
+}
+
+// createMTLSServer 创建要求客户端提供 testdata/client.crt 证书的HTTPS服务器
+func createMTLSServer(t *testing.T, data []byte, cert tls.Certificate, maxVersion uint16) *httptest.Server {
+	t.Helper()
+	clientPEM, err := os.ReadFile("testdata/client.crt")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MaxVersion:   maxVersion,
+	}
+	// 握手失败是部分用例的预期结果，不输出日志
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestTLSOptions 测试私有CA、客户端证书、最低TLS版本和公钥固定
+func TestTLSOptions(t *testing.T) {
+	data := testData(256 * 1024)
+	caPEM, ca, serverCert := createTestCA(t)
+	server := createMTLSServer(t, data, serverCert, 0)
+	tls12Server := createMTLSServer(t, data, serverCert, tls.VersionTLS12)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	clientCert := WithClientCert("testdata/client.crt", "testdata/client.key")
+	_, otherCA, _ := createTestCA(t)
+
+	tests := []struct {
+		name    string
+		server  *httptest.Server
+		opts    []OptionFunc
+		wantErr error // nil 表示应当下载成功
+	}{
+		{"no CA", server, []OptionFunc{clientCert}, errAny},
+		{"no client certificate", server, []OptionFunc{WithCACertFile(caFile)}, errAny},
+		{"PEM client certificate", server, []OptionFunc{WithCACertFile(caFile), clientCert}, nil},
+		{"PKCS#12 AES", server, []OptionFunc{WithCACerts(caPEM), WithClientCertPKCS12("testdata/client.p12", testCertPassword)}, nil},
+		{"PKCS#12 legacy RC2", server, []OptionFunc{WithCACerts(caPEM), WithClientCertPKCS12("testdata/client-legacy.p12", testCertPassword)}, nil},
+		{"PKCS#12 3DES with chain", server, []OptionFunc{WithCACerts(caPEM), WithClientCertPKCS12("testdata/client-3des.p12", testCertPassword)}, nil},
+		{"pin server key", server, []OptionFunc{WithCACerts(caPEM), clientCert, WithPinnedPublicKeys(PublicKeyPin(serverCert.Leaf))}, nil},
+		{"pin CA key in list", server, []OptionFunc{WithCACerts(caPEM), clientCert, WithPinnedPublicKeys(PublicKeyPin(otherCA) + ";" + PublicKeyPin(ca))}, nil},
+		{"pin mismatch", server, []OptionFunc{WithCACerts(caPEM), clientCert, WithPinnedPublicKeys(PublicKeyPin(otherCA))}, ErrPinMismatch},
+		{"pin with TLS config", server, []OptionFunc{WithTLSConfig(&tls.Config{InsecureSkipVerify: true}), clientCert, WithPinnedPublicKeys(PublicKeyPin(ca))}, ErrPinMismatch},
+		{"TLS 1.2 server", tls12Server, []OptionFunc{WithCACerts(caPEM), clientCert, WithMinTLSVersion(tls.VersionTLS12)}, nil},
+		{"TLS 1.3 required", tls12Server, []OptionFunc{WithCACerts(caPEM), clientCert, WithMinTLSVersion(tls.VersionTLS13)}, errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "secure.bin")
+			opts := append([]OptionFunc{
+				WithFileName(path),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+			}, tt.opts...)
+
+			err := newTestDownloader(t, tt.server.URL+"/secure.bin", opts...).Start()
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Start() error = %v", err)
+			case tt.wantErr == errAny && err == nil, tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+		})
+	}
+}
+
+// TestTLSOptionErrors 测试无效的TLS配置在 NewDownloader 时报告
+func TestTLSOptionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  OptionFunc
+	}{
+		{"missing CA file", WithCACertFile("testdata/missing.pem")},
+		{"CA file without certificates", WithCACertFile("testdata/client.key")},
+		{"missing key file", WithClientCert("testdata/client.crt", "testdata/missing.key")},
+		{"mismatched key", WithClientCert("testdata/client.crt", "testdata/client.crt")},
+		{"PKCS#12 wrong password", WithClientCertPKCS12("testdata/client.p12", "wrong")},
+		{"PKCS#12 not PKCS#12", WithClientCertPKCS12("testdata/client.crt", testCertPassword)},
+		{"unknown TLS version", WithMinTLSVersion(0x0305)},
+		{"invalid pin", WithPinnedPublicKeys("sha256//not-base64")},
+		{"short pin", WithPinnedPublicKeys("sha256//AAAA")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDownloader("https://files.example.com/file.bin", tt.opt)
+			if !errors.Is(err, ErrInvalidTLSOption) {
+				t.Errorf("NewDownloader() error = %v, want ErrInvalidTLSOption", err)
+			}
+		})
+	}
+}
+
+// TestLoadPKCS12 测试解析不同加密方式的PKCS#12文件
+func TestLoadPKCS12(t *testing.T) {
+	want, err := tls.LoadX509KeyPair("testdata/client.crt", "testdata/client.key")
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair() error = %v", err)
+	}
+
+	tests := []struct {
+		file      string
+		wantCerts int
+	}{
+		{"testdata/client.p12", 1},        // PBES2 + AES-256-CBC，HMAC-SHA256
+		{"testdata/client-legacy.p12", 1}, // 40位RC2（证书）和3DES（私钥），HMAC-SHA1
+		{"testdata/client-3des.p12", 2},   // 3DES，附带一个中间证书
+	}
+
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.file), func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			cert, err := loadPKCS12(data, testCertPassword)
+			if err != nil {
+				t.Fatalf("loadPKCS12() error = %v", err)
+			}
+			if len(cert.Certificate) != tt.wantCerts || !bytes.Equal(cert.Certificate[0], want.Certificate[0]) {
+				t.Fatalf("got %d certificates, want %d with client.crt first", len(cert.Certificate), tt.wantCerts)
+			}
+			key, ok := cert.PrivateKey.(crypto.Signer)
+			if !ok || !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(want.Leaf.PublicKey) {
+				t.Error("private key does not match client.key")
+			}
+
+			if _, err := loadPKCS12(data, "wrong"); !errors.Is(err, pkcs12.ErrIncorrectPassword) {
+				t.Errorf("wrong password error = %v, want pkcs12.ErrIncorrectPassword", err)
+			}
+			// 截断的文件应当返回错误而不是崩溃
+			for _, n := range []int{0, 1, 16, len(data) / 2, len(data) - 1} {
+				if _, err := loadPKCS12(data[:n], testCertPassword); err == nil {
+					t.Errorf("loadPKCS12() of %d bytes succeeded", n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package dl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc 将普通函数转换为 http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip 实现 http.RoundTripper 接口
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// createProxyServer 创建需要Basic认证的HTTP代理，对任何目标地址都直接返回 data，返回代理处理的请求数
+func createProxyServer(data []byte, user, pass string) (*httptest.Server, *atomic.Int32) {
+	var served atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := &http.Request{Header: http.Header{"Authorization": r.Header.Values("Proxy-Authorization")}}
+		if u, p, ok := auth.BasicAuth(); !ok || u != user || p != pass {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if !r.URL.IsAbs() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		served.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	return server, &served
+}
+
+// startSocksProxy 启动需要用户名密码认证的SOCKS5代理，所有连接都转发到 target，返回代理地址和建立的连接数
+func startSocksProxy(t *testing.T, user, pass, target string) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var connects atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks(c, user, pass, target, &connects)
+		}
+	}()
+	return ln.Addr().String(), &connects
+}
+
+// serveSocks 处理一个SOCKS5连接（RFC 1928，认证方式为RFC 1929用户名密码）
+func serveSocks(c net.Conn, user, pass, target string, connects *atomic.Int32) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	readN := func(n int) []byte {
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return b
+	}
+
+	// 协商认证方式：VER NMETHODS METHODS
+	head := readN(2)
+	if !bytes.Contains(readN(int(head[1])), []byte{2}) {
+		c.Write([]byte{5, 0xff})
+		return
+	}
+	c.Write([]byte{5, 2})
+
+	// 用户名密码认证：VER ULEN UNAME PLEN PASSWD
+	readN(1)
+	u := readN(int(readN(1)[0]))
+	p := readN(int(readN(1)[0]))
+	if string(u) != user || string(p) != pass {
+		c.Write([]byte{1, 1})
+		return
+	}
+	c.Write([]byte{1, 0})
+
+	// 连接请求：VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := readN(4)
+	switch req[3] {
+	case 1:
+		readN(4)
+	case 3:
+		readN(int(readN(1)[0]))
+	case 4:
+		readN(16)
+	}
+	readN(2)
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	connects.Add(1)
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, r)
+	io.Copy(c, upstream)
+}
+
+// TestProxy 测试带认证的HTTP和SOCKS5代理，以及代理与自定义客户端的组合
+func TestProxy(t *testing.T) {
+	data := testData(64 * 1024)
+	const user, pass = "alice", "p@ss/word"
+	creds := url.UserPassword(user, pass).String()
+
+	httpProxy, proxied := createProxyServer(data, user, pass)
+	defer httpProxy.Close()
+	httpProxyHost := strings.TrimPrefix(httpProxy.URL, "http://")
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer origin.Close()
+	socksAddr, connects := startSocksProxy(t, user, pass, strings.TrimPrefix(origin.URL, "http://"))
+
+	// 目标主机无法解析，只有经过代理才能下载成功
+	const target = "http://files.example.invalid/file.bin"
+	customClient := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 2}}
+
+	tests := []struct {
+		name    string
+		opts    []OptionFunc
+		used    *atomic.Int32 // 应当经过的代理的计数器
+		wantErr bool
+	}{
+		{"http credentials in URL", []OptionFunc{WithProxy("http://" + creds + "@" + httpProxyHost)}, proxied, false},
+		{"http without scheme", []OptionFunc{WithProxy(httpProxyHost), WithProxyAuth(user, pass)}, proxied, false},
+		{"proxy before client", []OptionFunc{WithProxy(httpProxyHost), WithProxyAuth(user, pass), WithHTTPClient(customClient)}, proxied, false},
+		{"http wrong password", []OptionFunc{WithProxy(httpProxyHost), WithProxyAuth(user, "wrong")}, nil, true},
+		{"socks5", []OptionFunc{WithProxy("socks5://" + creds + "@" + socksAddr)}, connects, false},
+		{"socks5h with proxy auth", []OptionFunc{WithHTTPClient(customClient), WithProxy("socks5h://" + socksAddr), WithProxyAuth(user, pass)}, connects, false},
+		{"socks5 without credentials", []OptionFunc{WithProxy("socks5://" + socksAddr)}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxied.Store(0)
+			connects.Store(0)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "proxied.bin")
+			opts := append([]OptionFunc{
+				WithFileName(path),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(4),
+				WithConnectTimeout(5 * time.Second),
+			}, tt.opts...)
+
+			err := newTestDownloader(t, target, opts...).Start()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			if tt.used.Load() == 0 {
+				t.Error("download did not go through the proxy")
+			}
+		})
+	}
+}
+
+// TestBuildTransport 测试传输层配置在自定义客户端的基础上生效，且与选项顺序无关
+func TestBuildTransport(t *testing.T) {
+	base := &http.Transport{MaxIdleConnsPerHost: 7}
+	client := &http.Client{Transport: base}
+	tlsConfig := &tls.Config{ServerName: "files.example.com"}
+	transportOpts := []OptionFunc{
+		WithProxy("http://proxy.example.com:8080"),
+		WithTLSConfig(tlsConfig),
+		WithResponseHeaderTimeout(3 * time.Second),
+	}
+
+	tests := []struct {
+		name string
+		opts []OptionFunc
+	}{
+		{"client first", append([]OptionFunc{WithHTTPClient(client)}, transportOpts...)},
+		{"client last", append(append([]OptionFunc{}, transportOpts...), WithHTTPClient(client))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDownloader(t, "https://files.example.com/file.bin", tt.opts...)
+			tr, ok := d.httpClient.Transport.(*http.Transport)
+			if !ok || tr == base {
+				t.Fatalf("transport = %T, want a copy of the client transport", d.httpClient.Transport)
+			}
+			if tr.MaxIdleConnsPerHost != 7 {
+				t.Errorf("MaxIdleConnsPerHost = %d, client settings were lost", tr.MaxIdleConnsPerHost)
+			}
+			req, _ := http.NewRequest(http.MethodGet, "https://files.example.com/file.bin", nil)
+			if u, _ := tr.Proxy(req); u == nil || u.Host != "proxy.example.com:8080" {
+				t.Errorf("proxy = %v, want proxy.example.com:8080", u)
+			}
+			if tr.TLSClientConfig == nil || tr.TLSClientConfig == tlsConfig || tr.TLSClientConfig.ServerName != "files.example.com" {
+				t.Errorf("TLSClientConfig = %+v, want a copy of the configured one", tr.TLSClientConfig)
+			}
+			if tr.ResponseHeaderTimeout != 3*time.Second {
+				t.Errorf("ResponseHeaderTimeout = %v", tr.ResponseHeaderTimeout)
+			}
+			// Clone 会为原传输层初始化HTTP/2所需的 TLSClientConfig，只检查配置的字段
+			if base.Proxy != nil || (base.TLSClientConfig != nil && base.TLSClientConfig.ServerName != "") {
+				t.Error("the caller's transport was modified")
+			}
+		})
+	}
+
+	// 没有传输层配置时直接使用客户端原有的传输层
+	d := newTestDownloader(t, "https://files.example.com/file.bin", WithHTTPClient(client))
+	if d.httpClient.Transport != base {
+		t.Errorf("transport was replaced without transport options")
+	}
+}
@@ -0,0 +1,367 @@
+package dl
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// maxPieceRetries 分块校验失败后最多重新下载的次数
+const maxPieceRetries = 3
+
+// Checksum 期望的文件校验和
+type Checksum struct {
+	// Algorithm 哈希算法：md5、sha-1、sha-256、sha-384、sha-512（也接受不带连字符的写法）
+	Algorithm string `json:"algorithm"`
+	// Sum 十六进制编码的校验和
+	Sum string `json:"sum"`
+}
+
+// PieceHashes 按固定长度分块的哈希列表
+type PieceHashes struct {
+	// Algorithm 哈希算法，取值同 Checksum.Algorithm
+	Algorithm string `json:"algorithm"`
+	// Length 每块的长度，最后一块可能更短
+	Length int64 `json:"length"`
+	// Hashes 按顺序排列的十六进制编码的分块哈希
+	Hashes []string `json:"hashes"`
+}
+
+// WithChecksum 设置下载完成后校验的文件哈希
+// 例如 WithChecksum("sha-256", "9f86d081884c7d65...")
+func WithChecksum(algorithm, sum string) OptionFunc {
+	return func(o *Options) {
+		o.Checksum = &Checksum{Algorithm: algorithm, Sum: sum}
+	}
+}
+
+// WithPieceHashes 设置分块哈希列表
+// 分段下载时每下载完一块立即校验，只重新下载损坏的块；单线程下载时在完成后整体校验
+func WithPieceHashes(pieces *PieceHashes) OptionFunc {
+	return func(o *Options) {
+		o.Pieces = pieces
+	}
+}
+
+// WithExpectedSize 设置预期的文件大小，与服务器返回的大小不一致时拒绝下载
+func WithExpectedSize(size int64) OptionFunc {
+	return func(o *Options) {
+		o.ExpectedSize = size
+	}
+}
+
+// normalizeHashName 将哈希算法名称统一为IANA注册的写法（如 sha256 -> sha-256）
+func normalizeHashName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "sha1", "sha-1":
+		return "sha-1"
+	case "sha256", "sha-256":
+		return "sha-256"
+	case "sha384", "sha-384":
+		return "sha-384"
+	case "sha512", "sha-512":
+		return "sha-512"
+	}
+	return name
+}
+
+// newHash 根据算法名称创建哈希函数
+func newHash(algorithm string) (hash.Hash, error) {
+	switch normalizeHashName(algorithm) {
+	case "md5":
+		return md5.New(), nil
+	case "sha-1":
+		return sha1.New(), nil
+	case "sha-256":
+		return sha256.New(), nil
+	case "sha-384":
+		return sha512.New384(), nil
+	case "sha-512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+// sumEqual 比较哈希结果与十六进制编码的期望值
+func sumEqual(h hash.Hash, want string) bool {
+	return hex.EncodeToString(h.Sum(nil)) == strings.ToLower(strings.TrimSpace(want))
+}
+
+// verify 校验下载完成的文件：先逐块校验，再校验整个文件
+func (d *Downloader) verify(path string) error {
+	if err := d.verifyPieces(path); err != nil {
+		return err
+	}
+	return d.verifyChecksum(path)
+}
+
+// verifyChecksum 校验整个文件的哈希
+func (d *Downloader) verifyChecksum(path string) error {
+	c := d.options.Checksum
+	if c == nil {
+		return nil
+	}
+
+	h, err := newHash(c.Algorithm)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file for verification: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = io.CopyBuffer(h, f, make([]byte, DefaultBufferSize)); err != nil {
+		return fmt.Errorf("failed to read file for verification: %w", err)
+	}
+	if !sumEqual(h, c.Sum) {
+		return fmt.Errorf("%w: %s %s", ErrChecksumMismatch, normalizeHashName(c.Algorithm), hex.EncodeToString(h.Sum(nil)))
+	}
+	return nil
+}
+
+// verifyPieces 逐块校验整个文件，返回所有校验失败的块
+func (d *Downloader) verifyPieces(path string) error {
+	p := d.options.Pieces
+	if p == nil || len(p.Hashes) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file for verification: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file for verification: %w", err)
+	}
+	if err = p.check(info.Size()); err != nil {
+		return err
+	}
+
+	bad, err := badPieces(f, 0, 0, info.Size(), p)
+	if err != nil {
+		return err
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%w: pieces %v", ErrPieceMismatch, bad)
+	}
+	return nil
+}
+
+// fetchPieces 下载分片[offset, seg.end)范围的数据并写入w，每下载完一块立即校验
+//
+// 损坏的块交给后台的修复协程，在分片其余部分继续下载的同时通过Range请求重新下载。
+// 断点续传时先校验分片文件中已有的完整块。分片边界与块边界对齐（见 plan），
+// 一个块重新下载 maxPieceRetries 次后仍然损坏时停止下载并返回 ErrPieceMismatch
+func (d *Downloader) fetchPieces(ctx context.Context, seg segment, offset int64, w io.Writer, partFilename string) error {
+	p := d.options.Pieces
+	offset = min(offset, seg.end)
+	if p == nil || len(p.Hashes) == 0 {
+		return d.fetch(ctx, offset, seg.end, seg.index, w)
+	}
+	// 分片文件可能以追加模式打开，单独打开一个可随机读写的句柄
+	f, err := os.OpenFile(partFilename, os.O_RDWR, FilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open part file: %w", err)
+	}
+	defer f.Close()
+
+	// 已完整下载的块直接校验，最后一个未下载完的块先把已有部分计入哈希
+	verified := offset - (offset-seg.start)%p.Length
+	if offset == seg.end {
+		verified = offset
+	}
+	bad, err := badPieces(f, seg.start, seg.start, verified, p)
+	if err != nil {
+		return err
+	}
+	h, err := newHash(p.Algorithm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(h, io.NewSectionReader(f, verified-seg.start, offset-verified)); err != nil {
+		return fmt.Errorf("failed to read part file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// 每个块最多入队一次，队列容量足以容纳分片中的所有块，写入不会阻塞
+	queue := make(chan int, (seg.end-seg.start+p.Length-1)/p.Length)
+	for _, k := range bad {
+		queue <- k
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for k := range queue {
+			if err := d.repairPiece(ctx, seg, f, k); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}()
+
+	pw := &pieceWriter{w: w, pieces: p, h: h, off: offset, end: seg.end, bad: queue}
+	if err = d.fetch(ctx, offset, seg.end, seg.index, pw); err != nil {
+		fail(err)
+	}
+	close(queue)
+	<-done
+	return firstErr
+}
+
+// repairPiece 通过Range请求重新下载第k块并再次校验
+func (d *Downloader) repairPiece(ctx context.Context, seg segment, f *os.File, k int) error {
+	p := d.options.Pieces
+	start := int64(k) * p.Length
+	end := min(start+p.Length, seg.end)
+	for attempt := 0; attempt < maxPieceRetries; attempt++ {
+		// 损坏的数据不计入下载进度
+		d.sw.restore(start - end)
+		if err := d.fetch(ctx, start, end, seg.index, io.NewOffsetWriter(f, start-seg.start)); err != nil {
+			return err
+		}
+		bad, err := badPieces(f, seg.start, start, end, p)
+		if err != nil {
+			return err
+		}
+		if len(bad) == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: pieces %v", ErrPieceMismatch, []int{k})
+}
+
+// pieceWriter 在写入数据的同时计算分块哈希，每写完一块立即校验，
+// 把校验失败的块序号发送到 bad
+type pieceWriter struct {
+	w      io.Writer
+	pieces *PieceHashes
+	h      hash.Hash // 当前块已写入部分的哈希
+	off    int64     // 下一个字节在文件中的偏移
+	end    int64     // 分片结束位置，最后一块可能更短
+	bad    chan<- int
+}
+
+// Write 实现io.Writer接口，只有实际写入的数据计入哈希
+func (pw *pieceWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	for rest := b[:n]; len(rest) > 0; {
+		k := pw.off / pw.pieces.Length
+		boundary := min((k+1)*pw.pieces.Length, pw.end)
+		m := min(int64(len(rest)), boundary-pw.off)
+		pw.h.Write(rest[:m])
+		pw.off += m
+		rest = rest[m:]
+		if pw.off == boundary {
+			if !sumEqual(pw.h, pw.pieces.Hashes[k]) {
+				pw.bad <- int(k)
+			}
+			pw.h.Reset()
+		}
+	}
+	return n, err
+}
+
+// check 校验分块数量与文件大小是否一致
+func (p *PieceHashes) check(size int64) error {
+	if p.Length <= 0 {
+		return fmt.Errorf("invalid piece length: %d", p.Length)
+	}
+	if n := (size + p.Length - 1) / p.Length; n != int64(len(p.Hashes)) {
+		return fmt.Errorf("%d piece hashes do not cover %d bytes with piece length %d", len(p.Hashes), size, p.Length)
+	}
+	return nil
+}
+
+// badPieces 校验文件[start, end)范围内的块，返回校验失败的块序号
+//
+// r 中第一个字节对应文件偏移 base，start 需与块边界对齐
+func badPieces(r io.ReaderAt, base, start, end int64, p *PieceHashes) ([]int, error) {
+	h, err := newHash(p.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var bad []int
+	buf := make([]byte, DefaultBufferSize)
+	for off := start; off < end; off += p.Length {
+		k := int(off / p.Length)
+		n := min(p.Length, end-off)
+
+		h.Reset()
+		read, err := io.CopyBuffer(h, io.NewSectionReader(r, off-base, n), buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read piece %d: %w", k, err)
+		}
+		if read != n || !sumEqual(h, p.Hashes[k]) {
+			bad = append(bad, k)
+		}
+	}
+	return bad, nil
+}
+
+// LoadPieceManifest 从JSON文件读取分块哈希列表
+//
+// 文件格式:
+//
+//	{"algorithm": "sha-256", "length": 1048576, "hashes": ["9f86d0...", "..."]}
+func LoadPieceManifest(path string) (*PieceHashes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read piece manifest: %w", err)
+	}
+
+	var p PieceHashes
+	if err = json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse piece manifest: %w", err)
+	}
+	if _, err = newHash(p.Algorithm); err != nil {
+		return nil, err
+	}
+	if p.Length <= 0 {
+		return nil, fmt.Errorf("invalid piece length: %d", p.Length)
+	}
+	return &p, nil
+}
+
+// ParseSHA1Pieces 解析BitTorrent格式的分块哈希列表（依次拼接的20字节SHA-1摘要）
+func ParseSHA1Pieces(length int64, pieces []byte) (*PieceHashes, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("invalid piece length: %d", length)
+	}
+	if len(pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("piece list length %d is not a multiple of %d", len(pieces), sha1.Size)
+	}
+
+	p := &PieceHashes{Algorithm: "sha-1", Length: length}
+	for off := 0; off < len(pieces); off += sha1.Size {
+		p.Hashes = append(p.Hashes, hex.EncodeToString(pieces[off:off+sha1.Size]))
+	}
+	return p, nil
+}
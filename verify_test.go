@@ -0,0 +1,233 @@
+package dl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// sha256Pieces 计算数据的分块SHA-256哈希
+func sha256Pieces(data []byte, length int) *PieceHashes {
+	p := &PieceHashes{Algorithm: "sha-256", Length: int64(length)}
+	for off := 0; off < len(data); off += length {
+		sum := sha256.Sum256(data[off:min(off+length, len(data))])
+		p.Hashes = append(p.Hashes, hex.EncodeToString(sum[:]))
+	}
+	return p
+}
+
+// createCorruptingTestServer 创建一个测试服务器，覆盖偏移 corruptAt 的响应前 times 次返回损坏的数据
+func createCorruptingTestServer(data []byte, corruptAt int64, times int32) (*httptest.Server, *atomic.Int64) {
+	var served atomic.Int64
+	var corrupted atomic.Int32
+	size := int64(len(data))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			return
+		}
+
+		var start, end int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		body := bytes.Clone(data[start : end+1])
+		if start <= corruptAt && corruptAt <= end && corrupted.Add(1) <= times {
+			body[corruptAt-start] ^= 0xff
+		}
+		served.Add(int64(len(body)))
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(body)
+	}))
+	return server, &served
+}
+
+// TestPieceRepair 测试分片完成后逐块校验，只重新下载损坏的块
+func TestPieceRepair(t *testing.T) {
+	const size = 64 * 1024
+	const pieceLen = 4 * 1024
+	data := testData(size)
+
+	tests := []struct {
+		name    string
+		times   int32
+		wantErr error
+	}{
+		{"corrupt once", 1, nil},
+		{"always corrupt", 100, ErrPieceMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, served := createCorruptingTestServer(data, 5*pieceLen+10, tt.times)
+			defer server.Close()
+
+			dir := t.TempDir()
+			target := filepath.Join(dir, "repaired.bin")
+
+			var mu sync.Mutex
+			var last int64
+			d := newTestDownloader(t, server.URL,
+				WithFileName(target),
+				WithBaseDir(filepath.Join(dir, "cache")),
+				WithConcurrency(3),
+				WithPieceHashes(sha256Pieces(data, pieceLen)),
+			)
+			d.OnProgress(func(loaded, total int64, rate string) {
+				mu.Lock()
+				last = loaded
+				mu.Unlock()
+			})
+
+			err := d.Start()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if !strings.Contains(err.Error(), "[5]") {
+					t.Errorf("error %q does not name piece 5", err)
+				}
+				return
+			}
+
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded file mismatch, err = %v", err)
+			}
+			// 只有损坏的块被重新下载
+			if n := served.Load(); n != size+pieceLen {
+				t.Errorf("served %d bytes, want %d", n, size+pieceLen)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if last != size {
+				t.Errorf("final progress = %d, want %d", last, size)
+			}
+		})
+	}
+}
+
+// TestPieceWriter 测试每写完一块立即校验，损坏的块在分片其余部分写入之前就被报告
+func TestPieceWriter(t *testing.T) {
+	const pieceLen = 1000
+	data := testData(4500)
+	pieces := sha256Pieces(data, pieceLen)
+	corrupt := bytes.Clone(data)
+	corrupt[10] ^= 0xff   // 第0块
+	corrupt[3600] ^= 0xff // 第3块
+
+	tests := []struct {
+		name    string
+		start   int64 // 分片中已下载的位置，从块中间开始时需要带上已有部分的哈希
+		wantBad []int
+	}{
+		{"from segment start", 0, []int{0, 3}},
+		{"resumed mid piece", 2500, []int{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := sha256.New()
+			h.Write(corrupt[tt.start/pieceLen*pieceLen : tt.start])
+			bad := make(chan int, len(pieces.Hashes))
+			var out bytes.Buffer
+			pw := &pieceWriter{w: &out, pieces: pieces, h: h, off: tt.start, end: int64(len(data)), bad: bad}
+
+			var got []int
+			for off := tt.start; off < int64(len(data)); off += 300 {
+				chunk := corrupt[off:min(off+300, int64(len(data)))]
+				if n, err := pw.Write(chunk); n != len(chunk) || err != nil {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+				for len(bad) > 0 {
+					k := <-bad
+					// 块k的最后一个字节所在的写入就应当报告该块
+					if end := min(int64(k+1)*pieceLen, int64(len(data))); end <= off || end > off+300 {
+						t.Errorf("piece %d reported after writing up to %d", k, off+int64(len(chunk)))
+					}
+					got = append(got, k)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantBad) {
+				t.Errorf("bad pieces = %v, want %v", got, tt.wantBad)
+			}
+			if !bytes.Equal(out.Bytes(), corrupt[tt.start:]) {
+				t.Error("data was not passed through unchanged")
+			}
+		})
+	}
+}
+
+// TestPieceAlignedPlan 测试设置分块哈希后分片边界与块边界对齐
+func TestPieceAlignedPlan(t *testing.T) {
+	d := newTestDownloader(t, "http://example.com/file",
+		WithConcurrency(3),
+		WithPieceHashes(&PieceHashes{Algorithm: "sha-1", Length: 1000}),
+	)
+
+	segments := d.plan(10500)
+	if segments[len(segments)-1].end != 10500 {
+		t.Errorf("last segment ends at %d, want 10500", segments[len(segments)-1].end)
+	}
+	for _, seg := range segments {
+		if seg.start%1000 != 0 {
+			t.Errorf("segment %d starts at %d, not aligned to piece length", seg.index, seg.start)
+		}
+	}
+	if d.parts != len(segments) {
+		t.Errorf("parts = %d, want %d", d.parts, len(segments))
+	}
+}
+
+// TestPieceListInputs 测试从JSON清单和BitTorrent格式读取分块哈希
+func TestPieceListInputs(t *testing.T) {
+	data := testData(10 * 1024)
+	want := sha256Pieces(data, 4096)
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "pieces.json")
+	os.WriteFile(manifest, []byte(fmt.Sprintf(`{"algorithm":"sha256","length":4096,"hashes":["%s","%s","%s"]}`,
+		want.Hashes[0], want.Hashes[1], want.Hashes[2])), FilePerm)
+
+	p, err := LoadPieceManifest(manifest)
+	if err != nil {
+		t.Fatalf("LoadPieceManifest() error = %v", err)
+	}
+	if p.Length != 4096 || fmt.Sprint(p.Hashes) != fmt.Sprint(want.Hashes) {
+		t.Errorf("LoadPieceManifest() = %+v, want %+v", p, want)
+	}
+
+	var raw []byte
+	for off := 0; off < len(data); off += 4096 {
+		sum := sha1.Sum(data[off:min(off+4096, len(data))])
+		raw = append(raw, sum[:]...)
+	}
+	p, err = ParseSHA1Pieces(4096, raw)
+	if err != nil {
+		t.Fatalf("ParseSHA1Pieces() error = %v", err)
+	}
+	if len(p.Hashes) != 3 || p.Algorithm != "sha-1" {
+		t.Errorf("ParseSHA1Pieces() = %+v", p)
+	}
+	if _, err = ParseSHA1Pieces(4096, raw[:30]); err == nil {
+		t.Error("ParseSHA1Pieces() with truncated list succeeded")
+	}
+
+	// 分块数量与文件大小不一致
+	if err = (&PieceHashes{Length: 4096, Hashes: []string{"a"}}).check(int64(len(data))); err == nil {
+		t.Error("check() with too few hashes succeeded")
+	}
+}